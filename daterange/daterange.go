@@ -0,0 +1,343 @@
+package daterange
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Range is a half-open time range [Start, End) resolved from an expression
+// in the search text
+type Range struct {
+	Start      time.Time
+	End        time.Time
+	Expression string
+}
+
+// Contains reports whether t falls inside the range
+func (r Range) Contains(t time.Time) bool {
+	return !t.Before(r.Start) && t.Before(r.End)
+}
+
+var months = map[string]time.Month{
+	"january": time.January, "jan": time.January,
+	"february": time.February, "feb": time.February,
+	"march": time.March, "mar": time.March,
+	"april": time.April, "apr": time.April,
+	"may":  time.May,
+	"june": time.June, "jun": time.June,
+	"july": time.July, "jul": time.July,
+	"august": time.August, "aug": time.August,
+	"september": time.September, "sep": time.September, "sept": time.September,
+	"october": time.October, "oct": time.October,
+	"november": time.November, "nov": time.November,
+	"december": time.December, "dec": time.December,
+}
+
+var weekdays = map[string]time.Weekday{
+	"monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	"sunday": time.Sunday,
+}
+
+// first month of each meteorological season (northern hemisphere)
+var seasons = map[string]time.Month{
+	"spring": time.March,
+	"summer": time.June,
+	"fall":   time.September,
+	"autumn": time.September,
+	"winter": time.December,
+}
+
+const (
+	monthPattern   = `(january|february|march|april|may|june|july|august|september|october|november|december|jan|feb|mar|apr|jun|jul|aug|sept|sep|oct|nov|dec)`
+	weekdayPattern = `(monday|tuesday|wednesday|thursday|friday|saturday|sunday)`
+	unitPattern    = `(day|week|month|year)s?`
+	yearPattern    = `((?:19|20)\d{2})`
+)
+
+type rule struct {
+	pattern *regexp.Regexp
+	resolve func(match []string, now time.Time) (time.Time, time.Time, bool)
+}
+
+// rules are tried in order, more specific expressions first. Text matched by
+// one rule is not matched again by a later one.
+var rules = []rule{
+	{regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`), resolveISODate},
+	{regexp.MustCompile(`\b` + monthPattern + `\s+(\d{1,2})(?:st|nd|rd|th)?(?:,?\s+` + yearPattern + `)?\b`), resolveMonthDay},
+	{regexp.MustCompile(`\b(?:last|past|previous)\s+(\d+)\s+` + unitPattern + `\b`), resolveLastN},
+	{regexp.MustCompile(`\b(\d+)\s+` + unitPattern + `\s+ago\b`), resolveAgo},
+	{regexp.MustCompile(`\b(this|last|previous)\s+weekend\b`), resolveWeekend},
+	{regexp.MustCompile(`\b(this|last|previous)\s+(week|month|year)\b`), resolveRelativeUnit},
+	{regexp.MustCompile(`\b(?:(last|previous|this)\s+|on\s+)?` + weekdayPattern + `\b`), resolveWeekday},
+	{regexp.MustCompile(`\b(today|yesterday)\b`), resolveDay},
+	{regexp.MustCompile(`\b(spring|summer|fall|autumn|winter)(?:\s+(?:of\s+)?` + yearPattern + `)?\b`), resolveSeason},
+	{regexp.MustCompile(`\b` + monthPattern + `(?:\s+(?:of\s+)?` + yearPattern + `)?\b`), resolveMonth},
+	// a bare number like "2000 steps" is not a year, it needs a preposition
+	{regexp.MustCompile(`\b(?:in|during|throughout|of)\s+` + yearPattern + `\b`), resolveYear},
+}
+
+// Resolve finds the relative and absolute date expressions in text and
+// resolves them against now, in now's location. Ranges are returned in the
+// order their expressions appear in the text.
+func Resolve(text string, now time.Time) []Range {
+	lower := []byte(strings.ToLower(text))
+
+	type found struct {
+		position int
+		r        Range
+	}
+	var matches []found
+
+	for _, rule := range rules {
+		for _, loc := range rule.pattern.FindAllSubmatchIndex(lower, -1) {
+			match := submatches(lower, loc)
+			if start, end, ok := rule.resolve(match, now); ok {
+				matches = append(matches, found{
+					position: loc[0],
+					r: Range{
+						Start:      start,
+						End:        end,
+						Expression: strings.TrimSpace(match[0]),
+					},
+				})
+			}
+			// mask the expression so later rules don't match parts of it, a
+			// rejected "feb 31" doesn't become february
+			for i := loc[0]; i < loc[1]; i++ {
+				lower[i] = ' '
+			}
+		}
+	}
+
+	ranges := make([]Range, 0, len(matches))
+	for len(matches) > 0 {
+		first := 0
+		for i := range matches {
+			if matches[i].position < matches[first].position {
+				first = i
+			}
+		}
+		ranges = append(ranges, matches[first].r)
+		matches = append(matches[:first], matches[first+1:]...)
+	}
+	return ranges
+}
+
+func submatches(text []byte, loc []int) []string {
+	match := make([]string, len(loc)/2)
+	for i := range match {
+		if loc[2*i] >= 0 {
+			match[i] = string(text[loc[2*i]:loc[2*i+1]])
+		}
+	}
+	return match
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// startOfWeek returns the Monday that starts t's week
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return startOfDay(t).AddDate(0, 0, -offset)
+}
+
+func resolveISODate(match []string, now time.Time) (time.Time, time.Time, bool) {
+	year, _ := strconv.Atoi(match[1])
+	month, _ := strconv.Atoi(match[2])
+	day, _ := strconv.Atoi(match[3])
+	if month < 1 || month > 12 {
+		return time.Time{}, time.Time{}, false
+	}
+	start, ok := date(year, time.Month(month), day, now.Location())
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, start.AddDate(0, 0, 1), true
+}
+
+func resolveMonthDay(match []string, now time.Time) (time.Time, time.Time, bool) {
+	day, _ := strconv.Atoi(match[2])
+	month := months[match[1]]
+	if match[3] != "" {
+		year, _ := strconv.Atoi(match[3])
+		start, ok := date(year, month, day, now.Location())
+		if !ok {
+			return time.Time{}, time.Time{}, false
+		}
+		return start, start.AddDate(0, 0, 1), true
+	}
+
+	// without a year the most recent such day is meant, "feb 29" being the
+	// last leap day
+	for year := now.Year(); year > now.Year()-8; year-- {
+		start, ok := date(year, month, day, now.Location())
+		if ok && !start.After(now) {
+			return start, start.AddDate(0, 0, 1), true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// date returns midnight of the given day, or false for days the month doesn't
+// have instead of normalizing "feb 31" into march
+func date(year int, month time.Month, day int, location *time.Location) (time.Time, bool) {
+	t := time.Date(year, month, day, 0, 0, 0, 0, location)
+	if day < 1 || t.Month() != month {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func addUnits(t time.Time, unit string, n int) time.Time {
+	switch unit {
+	case "day":
+		return t.AddDate(0, 0, n)
+	case "week":
+		return t.AddDate(0, 0, 7*n)
+	case "month":
+		return t.AddDate(0, n, 0)
+	default:
+		return t.AddDate(n, 0, 0)
+	}
+}
+
+func resolveLastN(match []string, now time.Time) (time.Time, time.Time, bool) {
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n <= 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	end := startOfDay(now).AddDate(0, 0, 1)
+	return addUnits(end, match[2], -n), end, true
+}
+
+func resolveAgo(match []string, now time.Time) (time.Time, time.Time, bool) {
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n <= 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	unit := match[2]
+	switch unit {
+	case "day":
+		start := startOfDay(now).AddDate(0, 0, -n)
+		return start, start.AddDate(0, 0, 1), true
+	case "week":
+		start := startOfWeek(now).AddDate(0, 0, -7*n)
+		return start, start.AddDate(0, 0, 7), true
+	case "month":
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -n, 0)
+		return start, start.AddDate(0, 1, 0), true
+	default:
+		start := time.Date(now.Year()-n, time.January, 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(1, 0, 0), true
+	}
+}
+
+func resolveWeekend(match []string, now time.Time) (time.Time, time.Time, bool) {
+	saturday := startOfWeek(now).AddDate(0, 0, 5)
+	if match[1] != "this" {
+		saturday = saturday.AddDate(0, 0, -7)
+	}
+	return saturday, saturday.AddDate(0, 0, 2), true
+}
+
+func resolveRelativeUnit(match []string, now time.Time) (time.Time, time.Time, bool) {
+	var start time.Time
+	switch match[2] {
+	case "week":
+		start = startOfWeek(now)
+	case "month":
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	default:
+		start = time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location())
+	}
+	end := addUnits(start, match[2], 1)
+	if match[1] != "this" {
+		start, end = addUnits(start, match[2], -1), start
+	}
+	return start, end, true
+}
+
+func resolveWeekday(match []string, now time.Time) (time.Time, time.Time, bool) {
+	weekday := weekdays[match[2]]
+	today := startOfDay(now)
+	daysBack := (int(today.Weekday()) - int(weekday) + 7) % 7
+	// "last tuesday" on a tuesday means a week ago, a bare "tuesday" means today
+	if daysBack == 0 && (match[1] == "last" || match[1] == "previous") {
+		daysBack = 7
+	}
+	start := today.AddDate(0, 0, -daysBack)
+	return start, start.AddDate(0, 0, 1), true
+}
+
+func resolveDay(match []string, now time.Time) (time.Time, time.Time, bool) {
+	start := startOfDay(now)
+	if match[1] == "yesterday" {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start, start.AddDate(0, 0, 1), true
+}
+
+func resolveSeason(match []string, now time.Time) (time.Time, time.Time, bool) {
+	firstMonth := seasons[match[1]]
+	if match[2] != "" {
+		year, _ := strconv.Atoi(match[2])
+		start := time.Date(year, firstMonth, 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 3, 0), true
+	}
+
+	// without a year the most recent season that has started is meant
+	start := time.Date(now.Year(), firstMonth, 1, 0, 0, 0, 0, now.Location())
+	if start.After(now) {
+		start = start.AddDate(-1, 0, 0)
+	}
+	return start, start.AddDate(0, 3, 0), true
+}
+
+func resolveMonth(match []string, now time.Time) (time.Time, time.Time, bool) {
+	// "may" alone is too often a verb to be read as a month
+	if match[1] == "may" && match[2] == "" {
+		return time.Time{}, time.Time{}, false
+	}
+	month := months[match[1]]
+	if match[2] != "" {
+		year, _ := strconv.Atoi(match[2])
+		start := time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 1, 0), true
+	}
+
+	start := time.Date(now.Year(), month, 1, 0, 0, 0, 0, now.Location())
+	if start.After(now) {
+		start = start.AddDate(-1, 0, 0)
+	}
+	return start, start.AddDate(0, 1, 0), true
+}
+
+func resolveYear(match []string, now time.Time) (time.Time, time.Time, bool) {
+	year, _ := strconv.Atoi(match[1])
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, now.Location())
+	return start, start.AddDate(1, 0, 0), true
+}
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime reads a timestamp in one of the common layouts, assuming location
+// when the value carries no zone
+func ParseTime(value string, location *time.Location) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
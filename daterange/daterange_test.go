@@ -0,0 +1,123 @@
+package daterange
+
+import (
+	"testing"
+	"time"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestResolve(t *testing.T) {
+	// a wednesday
+	now := time.Date(2024, time.March, 13, 15, 30, 0, 0, time.UTC)
+
+	type want struct {
+		expression string
+		start      time.Time
+		end        time.Time
+	}
+	tests := []struct {
+		name string
+		text string
+		want []want
+	}{
+		{"no expression", "what did I eat", nil},
+		{"iso date", "on 2023-07-04", []want{{"2023-07-04", day(2023, time.July, 4), day(2023, time.July, 5)}}},
+		{"invalid iso date", "on 2023-02-30", nil},
+		{"month day with year", "march 5th, 2022", []want{{"march 5th, 2022", day(2022, time.March, 5), day(2022, time.March, 6)}}},
+		{"month day this year", "march 5", []want{{"march 5", day(2024, time.March, 5), day(2024, time.March, 6)}}},
+		{"month day last year", "dec 25", []want{{"dec 25", day(2023, time.December, 25), day(2023, time.December, 26)}}},
+		{"month day out of range", "feb 31", nil},
+		{"leap day this year", "feb 29", []want{{"feb 29", day(2024, time.February, 29), day(2024, time.March, 1)}}},
+		{"leap day of non leap year", "feb 29 2023", nil},
+		{"last n days", "last 3 days", []want{{"last 3 days", day(2024, time.March, 11), day(2024, time.March, 14)}}},
+		{"past n weeks", "past 2 weeks", []want{{"past 2 weeks", day(2024, time.February, 29), day(2024, time.March, 14)}}},
+		{"last zero days", "last 0 days", nil},
+		{"days ago", "2 days ago", []want{{"2 days ago", day(2024, time.March, 11), day(2024, time.March, 12)}}},
+		{"weeks ago", "1 week ago", []want{{"1 week ago", day(2024, time.March, 4), day(2024, time.March, 11)}}},
+		{"months ago", "3 months ago", []want{{"3 months ago", day(2023, time.December, 1), day(2024, time.January, 1)}}},
+		{"years ago", "2 years ago", []want{{"2 years ago", day(2022, time.January, 1), day(2023, time.January, 1)}}},
+		{"this weekend", "this weekend", []want{{"this weekend", day(2024, time.March, 16), day(2024, time.March, 18)}}},
+		{"last weekend", "last weekend", []want{{"last weekend", day(2024, time.March, 9), day(2024, time.March, 11)}}},
+		{"this week", "this week", []want{{"this week", day(2024, time.March, 11), day(2024, time.March, 18)}}},
+		{"last month", "last month", []want{{"last month", day(2024, time.February, 1), day(2024, time.March, 1)}}},
+		{"previous year", "previous year", []want{{"previous year", day(2023, time.January, 1), day(2024, time.January, 1)}}},
+		{"bare weekday", "monday", []want{{"monday", day(2024, time.March, 11), day(2024, time.March, 12)}}},
+		{"weekday today", "wednesday", []want{{"wednesday", day(2024, time.March, 13), day(2024, time.March, 14)}}},
+		{"last weekday today", "last wednesday", []want{{"last wednesday", day(2024, time.March, 6), day(2024, time.March, 7)}}},
+		{"today", "today", []want{{"today", day(2024, time.March, 13), day(2024, time.March, 14)}}},
+		{"yesterday", "yesterday", []want{{"yesterday", day(2024, time.March, 12), day(2024, time.March, 13)}}},
+		{"season with year", "summer of 2021", []want{{"summer of 2021", day(2021, time.June, 1), day(2021, time.September, 1)}}},
+		{"season started", "winter", []want{{"winter", day(2023, time.December, 1), day(2024, time.March, 1)}}},
+		{"season not started", "summer", []want{{"summer", day(2023, time.June, 1), day(2023, time.September, 1)}}},
+		{"month with year", "april 2020", []want{{"april 2020", day(2020, time.April, 1), day(2020, time.May, 1)}}},
+		{"month not started", "november", []want{{"november", day(2023, time.November, 1), day(2023, time.December, 1)}}},
+		{"may as a verb", "i may have", nil},
+		{"may with year", "may 2019", []want{{"may 2019", day(2019, time.May, 1), day(2019, time.June, 1)}}},
+		{"year", "trips in 2019", []want{{"in 2019", day(2019, time.January, 1), day(2020, time.January, 1)}}},
+		{"number is not a year", "when did I walk 2000 steps", nil},
+		{"calories are not a year", "1950 calories", nil},
+		{"case insensitive", "Last Friday", []want{{"last friday", day(2024, time.March, 8), day(2024, time.March, 9)}}},
+		{"text order", "yesterday and on 2023-07-04", []want{
+			{"yesterday", day(2024, time.March, 12), day(2024, time.March, 13)},
+			{"2023-07-04", day(2023, time.July, 4), day(2023, time.July, 5)},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranges := Resolve(test.text, now)
+			if len(ranges) != len(test.want) {
+				t.Fatalf("Resolve(%q) = %v, want %d ranges", test.text, ranges, len(test.want))
+			}
+			for i, r := range ranges {
+				w := test.want[i]
+				if r.Expression != w.expression || !r.Start.Equal(w.start) || !r.End.Equal(w.end) {
+					t.Errorf("range %d = %q [%v, %v), want %q [%v, %v)",
+						i, r.Expression, r.Start, r.End, w.expression, w.start, w.end)
+				}
+			}
+		})
+	}
+}
+
+func TestRangeContains(t *testing.T) {
+	r := Range{Start: day(2024, time.March, 1), End: day(2024, time.March, 2)}
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{day(2024, time.March, 1), true},
+		{day(2024, time.March, 1).Add(23 * time.Hour), true},
+		{day(2024, time.March, 2), false},
+		{day(2024, time.February, 29), false},
+	}
+	for _, test := range tests {
+		if got := r.Contains(test.t); got != test.want {
+			t.Errorf("Contains(%v) = %v, want %v", test.t, got, test.want)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	location := time.FixedZone("UTC+2", 2*60*60)
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"2024-03-01T10:00:00Z", time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC), true},
+		{"2024-03-01T10:00:00", time.Date(2024, time.March, 1, 10, 0, 0, 0, location), true},
+		{"2024-03-01 10:00", time.Date(2024, time.March, 1, 10, 0, 0, 0, location), true},
+		{"2024-03-01", time.Date(2024, time.March, 1, 0, 0, 0, 0, location), true},
+		{"yesterday", time.Time{}, false},
+	}
+	for _, test := range tests {
+		got, ok := ParseTime(test.value, location)
+		if ok != test.ok || !got.Equal(test.want) {
+			t.Errorf("ParseTime(%q) = %v, %v, want %v, %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package idempotency
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	record    Record
+	expiresAt time.Time
+}
+
+// MemoryStore keeps records in process memory. Expired records are swept
+// when new keys are claimed.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]memoryEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]memoryEntry),
+	}
+}
+
+func (m *MemoryStore) Begin(key string, fingerprint string, lockTtl time.Duration) (State, Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := m.records[key]; ok && now.Before(entry.expiresAt) {
+		return stateOf(entry.record, fingerprint), entry.record, nil
+	}
+
+	for storedKey, entry := range m.records {
+		if now.After(entry.expiresAt) {
+			delete(m.records, storedKey)
+		}
+	}
+
+	record := Record{Fingerprint: fingerprint}
+	m.records[key] = memoryEntry{
+		record:    record,
+		expiresAt: now.Add(lockTtl),
+	}
+	return StateNew, record, nil
+}
+
+func (m *MemoryStore) Complete(key string, record Record, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record.Completed = true
+	m.records[key] = memoryEntry{
+		record:    record,
+		expiresAt: time.Now().Add(ttl),
+	}
+	return nil
+}
+
+func (m *MemoryStore) Release(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
@@ -0,0 +1,75 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "idempotency:"
+
+// RedisStore keeps records in Redis so retries landing on another instance
+// are recognized too
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+func (r *RedisStore) Begin(key string, fingerprint string, lockTtl time.Duration) (State, Record, error) {
+	ctx := context.Background()
+	record := Record{Fingerprint: fingerprint}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", Record{}, fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	claimed, err := r.client.SetNX(ctx, redisKeyPrefix+key, data, lockTtl).Result()
+	if err != nil {
+		return "", Record{}, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if claimed {
+		return StateNew, record, nil
+	}
+
+	stored, err := r.client.Get(ctx, redisKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// expired in between, let the client retry
+		return StateInProgress, Record{}, nil
+	}
+	if err != nil {
+		return "", Record{}, fmt.Errorf("failed to get idempotency record: %w", err)
+	}
+	if err := json.Unmarshal(stored, &record); err != nil {
+		return "", Record{}, fmt.Errorf("failed to decode idempotency record: %w", err)
+	}
+	return stateOf(record, fingerprint), record, nil
+}
+
+func (r *RedisStore) Complete(key string, record Record, ttl time.Duration) error {
+	record.Completed = true
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	if err := r.client.Set(context.Background(), redisKeyPrefix+key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save idempotency record: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisStore) Release(key string) error {
+	if err := r.client.Del(context.Background(), redisKeyPrefix+key).Err(); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package idempotency
+
+import "time"
+
+type State string
+
+const (
+	// StateNew means the caller now owns the key and must complete or release it
+	StateNew        State = "new"
+	StateInProgress State = "in_progress"
+	StateCompleted  State = "completed"
+	// StateMismatch means the key was used before with a different payload
+	StateMismatch State = "mismatch"
+)
+
+// Record is the stored outcome of a request
+type Record struct {
+	Fingerprint string `json:"fingerprint"`
+	Completed   bool   `json:"completed"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType"`
+	Body        []byte `json:"body"`
+}
+
+// Store remembers requests by idempotency key
+type Store interface {
+	// Begin claims the key for a request with the given fingerprint for at most
+	// lockTtl, or reports what is already known about the key
+	Begin(key string, fingerprint string, lockTtl time.Duration) (State, Record, error)
+	// Complete stores the response of a claimed key for ttl
+	Complete(key string, record Record, ttl time.Duration) error
+	// Release frees a claimed key so the request can be retried
+	Release(key string) error
+}
+
+func stateOf(record Record, fingerprint string) State {
+	if record.Fingerprint != fingerprint {
+		return StateMismatch
+	}
+	if !record.Completed {
+		return StateInProgress
+	}
+	return StateCompleted
+}
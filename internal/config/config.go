@@ -7,11 +7,23 @@ import (
 )
 
 type Config struct {
-	Server    ServerConfig
-	Clients   ClientsConfig
-	Prompts   PromptsConfig
-	RateLimit RateLimitConfig
-	JwtSecret string
+	Server        ServerConfig
+	Clients       ClientsConfig
+	Prompts       PromptsConfig
+	RoutePolicies RoutePoliciesConfig
+	Search        SearchConfig
+	Batch         BatchConfig
+	Timeline      TimelineConfig
+	Jobs          JobsConfig
+	// cap on requests calling an LLM at once
+	LlmConcurrency LlmConcurrencyConfig
+	Idempotency    IdempotencyConfig
+	Tokens         TokensConfig
+	MagicLink      MagicLinkConfig
+	Guests         GuestsConfig
+	SigningKeys    SigningKeysConfig
+	// master secret wrapping the per-user data keys of stored events
+	EncryptionKey string
 }
 
 type ServerConfig struct {
@@ -22,7 +34,10 @@ type ClientsConfig struct {
 	Gemini          GeminiConfig
 	OpenAI          OpenAIConfig
 	SignInWithApple SignInWithAppleConfig
+	Google          GoogleConfig
 	Superbase       SuperbaseConfig
+	Redis           RedisConfig
+	Smtp            SmtpConfig
 }
 
 type GeminiConfig struct {
@@ -31,9 +46,10 @@ type GeminiConfig struct {
 }
 
 type OpenAIConfig struct {
-	Key       string
-	Model     string
-	MaxTokens int
+	Key            string
+	Model          string
+	MaxTokens      int
+	EmbeddingModel string
 }
 
 type SignInWithAppleConfig struct {
@@ -41,17 +57,54 @@ type SignInWithAppleConfig struct {
 	TeamId        string
 	KeyId         string
 	PrivateKey    string
+	// Apple's key set for verifying id tokens, refetched after the interval
+	JwksUrl          string
+	JwksRefreshInSec int
+}
+
+type GoogleConfig struct {
+	// OAuth client ids of the apps, id tokens must be issued for one of them
+	ClientIds []string
+	// client ids of the mobile apps among them, whose sign ins need a nonce
+	MobileClientIds  []string
+	JwksUrl          string
+	JwksRefreshInSec int
+}
+
+type SmtpConfig struct {
+	// "smtp", or "log" to only log emails during local development
+	Backend  string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
 }
 
 type SuperbaseConfig struct {
-	Url              string
-	Key              string
-	AccountTableName string
-	UsageTableName   string
+	Url                   string
+	Key                   string
+	AccountTableName      string
+	UsageTableName        string
+	EventTableName        string
+	DataKeyTableName      string
+	RefreshTokenTableName string
+	AuditTableName        string
+	IdentityTableName     string
+	MagicLinkTableName    string
+	SigningKeyTableName   string
+	SessionTableName      string
+}
+
+type RedisConfig struct {
+	Addr     string
+	Password string
+	DB       int
 }
 
 type PromptsConfig struct {
-	EventPrompts EventPromptsConfig
+	EventPrompts    EventPromptsConfig
+	TimelinePrompts TimelinePromptsConfig
 }
 
 type EventPromptsConfig struct {
@@ -64,13 +117,163 @@ type EventPromptsConfig struct {
 
 	SearchContextHistoryPrompt           string
 	SearchContextSearchTextPrompt        string
+	SearchContextCurrentTimePrompt       string
 	SearchContextSystemInstructionPrompt string
 	SearchContextSystemResponsePrompt    string
+
+	SearchMapContextSystemInstructionPrompt string
+	SearchMapContextSystemResponsePrompt    string
+
+	SearchRewriteContextConversationPrompt      string
+	SearchRewriteContextSystemInstructionPrompt string
+	SearchRewriteContextSystemResponsePrompt    string
+
+	AggregateContextFieldsPrompt            string
+	AggregateContextSystemInstructionPrompt string
+	AggregateContextSystemResponsePrompt    string
+}
+
+type TimelinePromptsConfig struct {
+	SummaryContextRangePrompt             string
+	SummaryContextPrevSummaryPrompt       string
+	SummaryContextEventsPrompt            string
+	SummaryContextSystemInstructionPrompt string
+	SummaryContextSystemResponsePrompt    string
+}
+
+type SearchConfig struct {
+	// number of stored events retrieved for a query before asking the LLM
+	TopK int
+	// histories above this estimated token count are searched chunk by chunk
+	ChunkTokens int
+	// maximum number of chunks searched in parallel
+	MaxConcurrency int
+	// histories split into more chunks are refused with 413
+	MaxChunks int
+	// "memory" or "redis"
+	SessionBackend  string
+	SessionTtlInSec int
+	// number of recent turns kept as context for follow-up questions
+	SessionMaxTurns int
+}
+
+type BatchConfig struct {
+	MaxItems       int
+	MaxConcurrency int
+	// batch items are counted here instead of in the request rate limit
+	Quota RateLimitConfig
+}
+
+type TimelineConfig struct {
+	// events summarized per request, larger requests are refused with 413
+	MaxEvents int
+}
+
+type JobsConfig struct {
+	// "memory" or "redis"
+	Backend string
+	// capacity of the in-memory queue
+	QueueSize         int
+	Workers           int
+	MaxAttempts       int
+	RetryBackoffInSec int
+	// in-flight jobs not updated for this long are handed to another worker
+	LeaseTimeoutInSec int
+	// how long finished jobs are kept, and kept once their result was fetched
+	ResultTtlInSec    int
+	RetrievedTtlInSec int
+}
+
+type LlmConcurrencyConfig struct {
+	// LLM calls running at once, at least 1
+	MaxInFlight int
+	// calls waiting for a slot, more are rejected right away
+	MaxQueue int
+	// how long a call waits for a slot before it's rejected
+	QueueTimeoutInMs int
+	// sent as Retry-After with rejected requests
+	RetryAfterInSec int
+}
+
+type IdempotencyConfig struct {
+	// "memory" or "redis"
+	Backend string
+	// how long completed responses are replayed for a key
+	TtlInSec int
+	// how long a key stays claimed by a request that never completes
+	LockTtlInSec int
+	// largest body read to fingerprint a request with a key
+	MaxBodyInBytes int64
+}
+
+type TokensConfig struct {
+	AccessTokenTtlInSec  int
+	RefreshTokenTtlInSec int
+	// "memory" or "redis", where access tokens of deleted accounts are denied
+	RevocationBackend string
+	// how often every instance reloads the revoked sessions
+	SessionRefreshInSec int
+}
+
+type SigningKeysConfig struct {
+	// how often a new key is generated
+	RotationIntervalInSec int
+	// how long a key is published before it signs and after it stopped signing,
+	// at least the access token lifetime
+	OverlapInSec int
+	// how often instances reload the keys
+	RefreshInSec int
+}
+
+type MagicLinkConfig struct {
+	// link sent by email, %s is replaced by the sign in token
+	LinkUrl  string
+	Subject  string
+	TtlInSec int
+	// links sent to one address
+	SendLimit RateLimitConfig
+}
+
+type GuestsConfig struct {
+	// guests created from one client IP
+	CreationLimit RateLimitConfig
+}
+
+type RoutePoliciesConfig struct {
+	// "memory" or "redis", where the rate limits and the batch quota are counted
+	Backend string
+	// policy of routes that aren't listed
+	Default  string
+	Policies map[string]PolicyConfig
+	Routes   []RoutePolicyConfig
+}
+
+type PolicyConfig struct {
+	// "public", "authenticated" or "admin"
+	Access    string
+	RateLimit RateLimitConfig
+	// limits of users on a plan, e.g. "guest" for anonymous users, plans that
+	// aren't listed get RateLimit
+	PlanRateLimits map[string]RateLimitConfig
+}
+
+type RoutePolicyConfig struct {
+	Method string
+	// route pattern as registered, e.g. "/events/:id"
+	Path   string
+	Policy string
+	// limits counted for this route alone instead of the policy's, unset to
+	// share the policy's
+	RateLimit      RateLimitConfig
+	PlanRateLimits map[string]RateLimitConfig
 }
 
 type RateLimitConfig struct {
 	RateLimit   int
 	WindowInSec int64
+	// reject requests while the store can't be reached instead of letting
+	// them through, for quotas of costly work
+	FailClosed bool
 }
 
 func LoadConfig(configName string) (*Config, error) {
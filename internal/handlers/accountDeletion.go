@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/timemachine-app/timemachine-be/superbase"
+)
+
+const (
+	deletionStepAttempts = 3
+	deletionStepBackoff  = 500 * time.Millisecond
+)
+
+type deletionStep struct {
+	name string
+	run  func() error
+}
+
+// deleteAccount removes everything stored for the user. Every step can be run
+// again after it succeeded, so a deletion that failed halfway is finished by
+// calling it again. Outstanding tokens are cut off first and the data key is
+// dropped only after the Apple token it protects has been revoked.
+func (h *AccountHandler) deleteAccount(userId string) error {
+	user, err := h.supabaseClient.GetUserById(userId)
+	if errors.Is(err, superbase.ErrNotFound) {
+		// already deleted
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	accessTtl := time.Duration(h.tokensConfig.AccessTokenTtlInSec) * time.Second
+	steps := []deletionStep{
+		{"revoke access tokens", func() error {
+			return h.revocationStore.Revoke(userId, accessTtl)
+		}},
+		{"delete refresh tokens", func() error {
+			return h.supabaseClient.DeleteUserRefreshTokens(userId)
+		}},
+		{"delete sessions", func() error {
+			return h.supabaseClient.DeleteUserSessions(userId)
+		}},
+		{"revoke apple token", func() error {
+			return h.revokeAppleToken(user)
+		}},
+		{"delete identities", func() error {
+			return h.supabaseClient.DeleteUserIdentities(userId)
+		}},
+		{"delete events", func() error {
+			return h.supabaseClient.DeleteUserEvents(userId)
+		}},
+		{"delete usage events", func() error {
+			return h.supabaseClient.DeleteUserUsageEvents(userId)
+		}},
+		{"delete data key", func() error {
+			h.retriever.Forget(userId)
+			return h.vault.Forget(userId)
+		}},
+		{"delete user", func() error {
+			return h.supabaseClient.DeleteUser(userId)
+		}},
+	}
+
+	for _, step := range steps {
+		if err := runWithRetry(step.run); err != nil {
+			return fmt.Errorf("failed to %s: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+func runWithRetry(run func() error) error {
+	var err error
+	for attempt := 1; attempt <= deletionStepAttempts; attempt++ {
+		if err = run(); err == nil {
+			return nil
+		}
+		if attempt < deletionStepAttempts {
+			time.Sleep(deletionStepBackoff * time.Duration(attempt))
+		}
+	}
+	return err
+}
+
+// revokeAppleToken ends the user's sign in with Apple as the App Store
+// guidelines require for deleted accounts
+func (h *AccountHandler) revokeAppleToken(user superbase.User) error {
+	if user.AppleRefreshToken == "" {
+		// accounts that signed in with Apple before refresh tokens were
+		// stored can't be revoked, the user has to stop using Apple ID with
+		// the app in their Apple settings
+		if isAppleSubject(user.ExternalUserId) {
+			log.Printf("no Apple refresh token stored for user %s, sign in with Apple not revoked", user.UserId)
+		}
+		return nil
+	}
+
+	refreshToken, err := h.vault.Decrypt(user.UserId, user.AppleRefreshToken)
+	if err != nil {
+		return err
+	}
+
+	clientSecret, err := generateClientSecret(h.signInWithAppleConfig)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.PostForm("https://appleid.apple.com/auth/revoke", url.Values{
+		"client_id":       {h.signInWithAppleConfig.AppleClientId},
+		"client_secret":   {clientSecret},
+		"token":           {string(refreshToken)},
+		"token_type_hint": {"refresh_token"},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to revoke apple token, status code: %d", resp.StatusCode)
+	}
+
+	// a retried deletion must not need the token again, its data key may be gone by then
+	return h.supabaseClient.UpdateUserAppleRefreshToken(user.UserId, "")
+}
@@ -1,130 +1,128 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"io"
+	"log"
 	"net/http"
-	"net/url"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
+	"github.com/timemachine-app/timemachine-be/idempotency"
 	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/keyset"
+	"github.com/timemachine-app/timemachine-be/mailer"
+	"github.com/timemachine-app/timemachine-be/ratelimit"
+	"github.com/timemachine-app/timemachine-be/revocation"
 	"github.com/timemachine-app/timemachine-be/superbase"
+	"github.com/timemachine-app/timemachine-be/util"
+	"github.com/timemachine-app/timemachine-be/vault"
+	"github.com/timemachine-app/timemachine-be/vectorindex"
 )
 
-type AppleTokenResponse struct {
-	AccessToken string `json:"access_token"`
-	IDToken     string `json:"id_token"`
-}
-
 type AccountHandler struct {
 	signInWithAppleConfig config.SignInWithAppleConfig
 	supabaseClient        *superbase.SupabaseClient
-	jwtSecret             string
+	vault                 *vault.Vault
+	retriever             *vectorindex.Retriever
+	providers             map[string]SignInProvider
+	appleProvider         *appleProvider
+	emailProvider         *emailProvider
+	tokensConfig          config.TokensConfig
+	revocationStore       revocation.Store
+	sessionSet            *revocation.SessionSet
+	keyset                *keyset.Keyset
+	guestLimit            *util.RateLimiter
+	idempotencyStore      idempotency.Store
 }
 
-func NewAccountHandler(signInWithAppleConfig config.SignInWithAppleConfig, supabaseClient *superbase.SupabaseClient, jwtSecret string) *AccountHandler {
+func NewAccountHandler(
+	signInWithAppleConfig config.SignInWithAppleConfig,
+	googleConfig config.GoogleConfig,
+	magicLinkConfig config.MagicLinkConfig,
+	guestsConfig config.GuestsConfig,
+	sender mailer.Sender,
+	supabaseClient *superbase.SupabaseClient,
+	vault *vault.Vault,
+	retriever *vectorindex.Retriever,
+	tokensConfig config.TokensConfig,
+	revocationStore revocation.Store,
+	sessionSet *revocation.SessionSet,
+	keyset *keyset.Keyset,
+	rateLimitStore ratelimit.Store,
+	idempotencyStore idempotency.Store) *AccountHandler {
+	appleProvider := newAppleProvider(signInWithAppleConfig)
+	emailProvider := newEmailProvider(magicLinkConfig, sender, supabaseClient, rateLimitStore)
 	return &AccountHandler{
 		signInWithAppleConfig: signInWithAppleConfig,
 		supabaseClient:        supabaseClient,
-		jwtSecret:             jwtSecret,
+		vault:                 vault,
+		retriever:             retriever,
+		providers: map[string]SignInProvider{
+			providerApple:     appleProvider,
+			providerGoogle:    newGoogleProvider(googleConfig),
+			providerEmail:     emailProvider,
+			providerAnonymous: newAnonymousProvider(),
+		},
+		appleProvider:    appleProvider,
+		emailProvider:    emailProvider,
+		tokensConfig:     tokensConfig,
+		revocationStore:  revocationStore,
+		sessionSet:       sessionSet,
+		keyset:           keyset,
+		guestLimit:       util.NewRateLimiter("guests", guestsConfig.CreationLimit, rateLimitStore),
+		idempotencyStore: idempotencyStore,
 	}
 }
 
-func (h *AccountHandler) SignInWithApple(c *gin.Context) {
+// DeleteAccount deletes the authenticated user's account. Admins may name
+// another user's account in the body, which is recorded in the audit table.
+func (h *AccountHandler) DeleteAccount(c *gin.Context) {
 	var req struct {
-		Code       string `json:"code"`
-		ExternalId string `json:"externalId"`
-		Email      string `json:"email"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
-		return
-	}
-
-	clientSecret, err := generateClientSecret(h.signInWithAppleConfig)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
-		return
-	}
-
-	resp, err := http.PostForm("https://appleid.apple.com/auth/token", url.Values{
-		"client_id":     {h.signInWithAppleConfig.AppleClientId},
-		"client_secret": {clientSecret},
-		"code":          {req.Code},
-		"grant_type":    {"authorization_code"},
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
-		return
+		UserId string `json:"userId"`
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+	actorUserId, ok := authenticatedUserId(c)
+	if !ok {
 		return
 	}
-
-	var tokenResp AppleTokenResponse
-	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	user, err := h.supabaseClient.GetUser(req.ExternalId)
-	if err != nil {
-		if err.Error() != "user not found" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+	userId := actorUserId
+	if req.UserId != "" && req.UserId != actorUserId {
+		if c.GetString(util.UserRoleContextKey) != util.AdminRole {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
 			return
 		}
+		userId = req.UserId
+	}
 
-		user = superbase.User{
-			Email:          req.Email,
-			ExternalUserId: req.ExternalId,
-		}
-
-		err := h.supabaseClient.AddUser(user)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
-			return
+	deleteErr := h.deleteAccount(userId)
+	if userId != actorUserId {
+		// recorded once the outcome is known, an unrecorded deletion fails
+		// and its retry is recorded
+		outcome := superbase.AuditOutcomeSucceeded
+		if deleteErr != nil {
+			outcome = superbase.AuditOutcomeFailed
 		}
-
-		// get user again after creating one
-		user, err = h.supabaseClient.GetUser(req.ExternalId)
+		err := h.supabaseClient.AddAuditEvent(superbase.AuditEvent{
+			ActorUserId:  actorUserId,
+			Action:       "delete_account",
+			TargetUserId: userId,
+			Outcome:      outcome,
+		})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			log.Printf("failed to record deletion of user %s by %s: %v", userId, actorUserId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
 			return
 		}
 	}
-
-	// Generate JWT token for the user
-	jwtToken, err := GenerateJWTToken(user, h.jwtSecret)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate JWT token"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"jwt_token": jwtToken,
-		"userId":    user.UserId,
-	})
-}
-
-func (h *AccountHandler) DeleteAccount(c *gin.Context) {
-	var req struct {
-		UserId string `json:"userId"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-		return
-	}
-
-	err := h.supabaseClient.DeleteUser(req.UserId)
-	if err != nil {
+	if deleteErr != nil {
+		// the completed steps aren't undone, retrying the request finishes the deletion
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
 		return
 	}
@@ -132,31 +130,19 @@ func (h *AccountHandler) DeleteAccount(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": "true"})
 }
 
-func generateClientSecret(singInWithAppleConfig config.SignInWithAppleConfig) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
-		"iss": singInWithAppleConfig.TeamId,
-		"iat": time.Now().Unix(),
-		"exp": time.Now().Add(time.Hour).Unix(),
-		"aud": "https://appleid.apple.com",
-		"sub": singInWithAppleConfig.AppleClientId,
-	})
-	token.Header["kid"] = singInWithAppleConfig.KeyId
-
-	privateKey, err := jwt.ParseECPrivateKeyFromPEM([]byte(singInWithAppleConfig.PrivateKey))
-	if err != nil {
-		return "", err
-	}
-
-	return token.SignedString(privateKey)
-}
-
-func GenerateJWTToken(user superbase.User, jwtSecret string) (string, error) {
+func GenerateJWTToken(user superbase.User, sessionId string, keyset *keyset.Keyset, ttl time.Duration) (string, error) {
 	claims := jwt.MapClaims{
 		"sub": user.UserId,
+		"sid": sessionId,
 		"iat": time.Now().Unix(),
-		"exp": time.Now().Add(time.Hour * 72).Unix(),
+		"exp": time.Now().Add(ttl).Unix(),
+	}
+	if user.Role != "" {
+		claims["role"] = user.Role
+	}
+	if user.Plan != "" {
+		claims["plan"] = user.Plan
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(jwtSecret))
+	return keyset.Sign(claims)
 }
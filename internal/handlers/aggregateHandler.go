@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/timemachine-app/timemachine-be/daterange"
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/openai"
+	"github.com/timemachine-app/timemachine-be/querydsl"
+	"github.com/timemachine-app/timemachine-be/superbase"
+	"github.com/timemachine-app/timemachine-be/util"
+	"github.com/timemachine-app/timemachine-be/vault"
+)
+
+// field of an event's time in a flattened history event
+const eventTimeField = "eventTime"
+
+type AggregateHandler struct {
+	openAIConfig   config.OpenAIConfig
+	eventPrompts   config.EventPromptsConfig
+	supabaseClient *superbase.SupabaseClient
+	vault          *vault.Vault
+	llmLimiter     *util.ConcurrencyLimiter
+}
+
+func NewAggregateHandler(
+	openAIConfig config.OpenAIConfig,
+	eventPrompts config.EventPromptsConfig,
+	supabaseClient *superbase.SupabaseClient,
+	vault *vault.Vault,
+	llmLimiter *util.ConcurrencyLimiter) *AggregateHandler {
+	return &AggregateHandler{
+		openAIConfig:   openAIConfig,
+		eventPrompts:   eventPrompts,
+		supabaseClient: supabaseClient,
+		vault:          vault,
+		llmLimiter:     llmLimiter,
+	}
+}
+
+// Aggregate answers counting questions such as "how many times did I go to
+// the gym in March". The LLM only translates the question into a query; the
+// query runs over the events in Go, so the numbers are exact.
+func (h *AggregateHandler) Aggregate(c *gin.Context) {
+	searchText := c.PostForm(inputFormSearchText)
+	if searchText == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	now, err := searchClock(c.PostForm(inputFormTimezone), c.PostForm(inputFormCurrentTime))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+	ranges := daterange.Resolve(searchText, now)
+
+	var events []querydsl.Event
+	var fields map[string]querydsl.FieldType
+	if history := c.PostForm(inputFormHistory); history != "" {
+		allEvents, err := querydsl.ParseEvents(history)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+			return
+		}
+		// the fields of the whole history, a range without events still
+		// knows them
+		fields = querydsl.Fields(allEvents, now.Location())
+		events = eventsInRanges(allEvents, ranges, now.Location())
+	} else {
+		userId := c.GetString(util.UserIdContextKey)
+		if userId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+			return
+		}
+		events, fields, err = h.storedEvents(userId, ranges, now.Location())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			return
+		}
+	}
+
+	query, err := h.translate(c.Request.Context(), searchText, fields, now)
+	var invalidQuery *querydsl.ValidationError
+	if errors.As(err, &invalidQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		respondLlmError(c, h.llmLimiter, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":                 query,
+		"results":               querydsl.Execute(query, events, now.Location()),
+		searchResolvedRangesKey: toResolvedRanges(ranges),
+	})
+}
+
+// translate asks the LLM for the query answering the search text
+func (h *AggregateHandler) translate(ctx context.Context, searchText string, fields map[string]querydsl.FieldType, now time.Time) (querydsl.Query, error) {
+	contextPrompt := fmt.Sprintf("%s: %s. ", h.eventPrompts.AggregateContextFieldsPrompt, querydsl.DescribeFields(fields))
+	contextPrompt = contextPrompt + fmt.Sprintf("%s: %s. ", h.eventPrompts.SearchContextCurrentTimePrompt, now.Format(time.RFC3339))
+	contextPrompt = contextPrompt + fmt.Sprintf("%s: %s. ", h.eventPrompts.SearchContextSearchTextPrompt, searchText)
+
+	var response string
+	err := h.llmLimiter.Call(ctx, func() (err error) {
+		response, err = openai.CallOpenAIAPI(
+			contextPrompt, nil,
+			h.eventPrompts.AggregateContextSystemInstructionPrompt,
+			h.eventPrompts.AggregateContextSystemResponsePrompt,
+			h.openAIConfig.Key,
+			h.openAIConfig.Model,
+			h.openAIConfig.MaxTokens)
+		return err
+	})
+	if err != nil {
+		return querydsl.Query{}, err
+	}
+
+	var query querydsl.Query
+	if err := json.Unmarshal([]byte(util.CleanLLMJson(response)), &query); err != nil {
+		return querydsl.Query{}, fmt.Errorf("failed to decode query: %w", err)
+	}
+	if err := query.Validate(fields); err != nil {
+		return querydsl.Query{}, fmt.Errorf("invalid query: %w", err)
+	}
+	return query, nil
+}
+
+// storedEvents returns the user's stored events in the ranges, or all of them
+// without ranges, and the fields known to their events. Only the events in
+// the ranges are read, and the fields come from them and the most recent
+// events.
+func (h *AggregateHandler) storedEvents(userId string, ranges []daterange.Range, location *time.Location) ([]querydsl.Event, map[string]querydsl.FieldType, error) {
+	if len(ranges) == 0 {
+		events, err := h.decryptEventPages(userId, func(offset int) ([]superbase.StoredEvent, error) {
+			return h.supabaseClient.GetEvents(userId, maxEventsPageSize, offset)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return events, querydsl.Fields(events, location), nil
+	}
+
+	var events []querydsl.Event
+	seen := map[string]bool{}
+	for _, r := range ranges {
+		rangeEvents, err := h.decryptEventPages(userId, func(offset int) ([]superbase.StoredEvent, error) {
+			return h.supabaseClient.GetEventsBetween(userId, r.Start, r.End, maxEventsPageSize, offset)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		// ranges may overlap
+		for _, event := range rangeEvents {
+			if !seen[event.Id] {
+				seen[event.Id] = true
+				events = append(events, event)
+			}
+		}
+	}
+
+	recentStoredEvents, err := h.supabaseClient.GetEvents(userId, maxEventsPageSize, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	recentEvents, err := h.parseStoredEvents(userId, recentStoredEvents)
+	if err != nil {
+		return nil, nil, err
+	}
+	return events, querydsl.Fields(append(recentEvents, events...), location), nil
+}
+
+// decryptEventPages reads every page of events and decrypts them
+func (h *AggregateHandler) decryptEventPages(userId string, page func(offset int) ([]superbase.StoredEvent, error)) ([]querydsl.Event, error) {
+	var events []querydsl.Event
+	for offset := 0; ; offset += maxEventsPageSize {
+		storedEvents, err := page(offset)
+		if err != nil {
+			return nil, err
+		}
+		pageEvents, err := h.parseStoredEvents(userId, storedEvents)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, pageEvents...)
+
+		if len(storedEvents) < maxEventsPageSize {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (h *AggregateHandler) parseStoredEvents(userId string, storedEvents []superbase.StoredEvent) ([]querydsl.Event, error) {
+	responses := make([]StoredEventResponse, 0, len(storedEvents))
+	for _, storedEvent := range storedEvents {
+		content, err := h.vault.Decrypt(userId, storedEvent.Content)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, toStoredEventResponse(storedEvent, content))
+	}
+
+	history, err := json.Marshal(responses)
+	if err != nil {
+		return nil, err
+	}
+	return querydsl.ParseEvents(string(history))
+}
+
+// eventsInRanges keeps the events whose eventTime falls in any of the ranges
+// or can't be read, like filterHistoryByRanges
+func eventsInRanges(events []querydsl.Event, ranges []daterange.Range, location *time.Location) []querydsl.Event {
+	keep := eventTimeFilter(ranges, location)
+	if keep == nil {
+		return events
+	}
+
+	filtered := []querydsl.Event{}
+	for _, event := range events {
+		eventTime, _ := event.Fields[eventTimeField].(string)
+		if keep(eventTime) {
+			filtered = append(filtered, event)
+		}
+	}
+	return filtered
+}
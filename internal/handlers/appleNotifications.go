@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/timemachine-app/timemachine-be/idempotency"
+	"github.com/timemachine-app/timemachine-be/superbase"
+)
+
+const (
+	appleEventEmailDisabled  = "email-disabled"
+	appleEventEmailEnabled   = "email-enabled"
+	appleEventConsentRevoked = "consent-revoked"
+	appleEventAccountDelete  = "account-delete"
+)
+
+// appleNotificationMaxAge is how long after Apple issued a notification it is
+// accepted. Apple's notifications don't expire, within this window they are
+// told apart by their jti so that each is handled once.
+const appleNotificationMaxAge = 5 * time.Minute
+
+// appleNotificationKeyPrefix keys handled notifications in the idempotency store
+const appleNotificationKeyPrefix = "apple-notification:"
+
+// appleNotificationEvent is the event Apple sends as a JSON string in the
+// events claim of a notification
+type appleNotificationEvent struct {
+	Type    string `json:"type"`
+	Subject string `json:"sub"`
+	Email   string `json:"email"`
+}
+
+// AppleNotification handles Apple's server to server notifications about a
+// user's Apple ID. Failures answer 500 so Apple delivers the notification
+// again, which is safe as every action can be repeated. Notifications issued
+// more than appleNotificationMaxAge ago and notifications already handled are
+// rejected, so a captured payload can't be replayed.
+func (h *AccountHandler) AppleNotification(c *gin.Context) {
+	var req struct {
+		Payload string `json:"payload"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.Payload == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	claims, err := verifyProviderToken(req.Payload, h.appleProvider.jwks,
+		[]string{appleIssuer}, []string{h.signInWithAppleConfig.AppleClientId})
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" || !issuedRecently(claims, appleNotificationMaxAge) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var event appleNotificationEvent
+	events, _ := claims["events"].(string)
+	if err := json.Unmarshal([]byte(events), &event); err != nil || event.Subject == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	key := appleNotificationKeyPrefix + jti
+	state, _, err := h.idempotencyStore.Begin(key, "", appleNotificationMaxAge)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+	switch state {
+	case idempotency.StateNew:
+	case idempotency.StateCompleted:
+		c.JSON(http.StatusOK, gin.H{"success": "true"})
+		return
+	default:
+		// Apple delivers it again after the other delivery finished or failed
+		c.JSON(http.StatusConflict, gin.H{"error": "notification is being handled"})
+		return
+	}
+
+	if err := h.handleAppleEvent(event); err != nil {
+		if err := h.idempotencyStore.Release(key); err != nil {
+			log.Printf("failed to release apple notification %s: %v", jti, err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	record := idempotency.Record{Completed: true, Status: http.StatusOK}
+	if err := h.idempotencyStore.Complete(key, record, appleNotificationMaxAge); err != nil {
+		log.Printf("failed to record apple notification %s: %v", jti, err)
+	}
+	c.JSON(http.StatusOK, gin.H{"success": "true"})
+}
+
+// issuedRecently reports whether the token's iat lies within maxAge, allowing
+// for clock skew into the future as well
+func issuedRecently(claims jwt.MapClaims, maxAge time.Duration) bool {
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return false
+	}
+	age := time.Since(time.Unix(int64(iat), 0))
+	return age <= maxAge && age >= -maxAge
+}
+
+// handleAppleEvent applies the event to the user of its Apple subject
+func (h *AccountHandler) handleAppleEvent(event appleNotificationEvent) error {
+	userId, err := h.appleUserId(event.Subject)
+	if errors.Is(err, superbase.ErrNotFound) {
+		// nothing to do for users we don't know or already deleted
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case appleEventEmailEnabled:
+		err = h.supabaseClient.UpdateUserEmail(userId, strings.ToLower(event.Email))
+	case appleEventEmailDisabled:
+		// mail to the private relay address isn't forwarded anymore
+		err = h.supabaseClient.UpdateUserEmail(userId, "")
+	case appleEventConsentRevoked:
+		err = h.disableAccount(userId)
+	case appleEventAccountDelete:
+		// the Apple ID is gone, there is no token left to revoke
+		err = h.supabaseClient.UpdateUserAppleRefreshToken(userId, "")
+		if err == nil {
+			err = h.deleteAccount(userId)
+		}
+	}
+	return err
+}
+
+// disableAccount signs the user out everywhere until they sign in again
+func (h *AccountHandler) disableAccount(userId string) error {
+	if err := h.supabaseClient.SetUserDisabled(userId, true); err != nil {
+		return err
+	}
+	accessTtl := time.Duration(h.tokensConfig.AccessTokenTtlInSec) * time.Second
+	if err := h.revocationStore.Revoke(userId, accessTtl); err != nil {
+		return err
+	}
+	if err := h.supabaseClient.DeleteUserRefreshTokens(userId); err != nil {
+		return err
+	}
+	if err := h.supabaseClient.RevokeUserSessions(userId); err != nil {
+		return err
+	}
+	// Apple invalidated it together with the consent
+	return h.supabaseClient.UpdateUserAppleRefreshToken(userId, "")
+}
+
+// appleUserId returns the user signed in with the Apple subject
+func (h *AccountHandler) appleUserId(subject string) (string, error) {
+	identity, err := h.supabaseClient.GetUserIdentity(providerApple, subject)
+	if err == nil {
+		return identity.UserId, nil
+	}
+	if !errors.Is(err, superbase.ErrNotFound) {
+		return "", err
+	}
+
+	// accounts created before identities were recorded are keyed by their Apple subject
+	user, err := h.supabaseClient.GetUser(subject)
+	if err != nil {
+		return "", err
+	}
+	return user.UserId, nil
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/timemachine-app/timemachine-be/idempotency"
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/mailer"
+	"github.com/timemachine-app/timemachine-be/ratelimit"
+	"github.com/timemachine-app/timemachine-be/revocation"
+	"github.com/timemachine-app/timemachine-be/superbase"
+	"github.com/timemachine-app/timemachine-be/util"
+	"github.com/timemachine-app/timemachine-be/vault"
+	"github.com/timemachine-app/timemachine-be/vectorindex"
+)
+
+const (
+	appleSubject = "apple-subject"
+	appleUserId  = "user-1"
+)
+
+// fakeSupabase answers the Supabase REST API for one user signed in with
+// Apple and records the requests that change data
+type fakeSupabase struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	writes []string
+	// writes fail while set
+	failWrites bool
+}
+
+func newFakeSupabase(t *testing.T) *fakeSupabase {
+	fake := &fakeSupabase{}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		table := strings.TrimPrefix(r.URL.Path, "/rest/v1/")
+		query, _ := url.QueryUnescape(r.URL.RawQuery)
+
+		if r.Method == http.MethodGet {
+			switch {
+			case table == "identities" && strings.Contains(query, "Subject=eq."+appleSubject):
+				fmt.Fprintf(w, `[{"Provider":"apple","Subject":%q,"UserId":%q}]`, appleSubject, appleUserId)
+			case table == "accounts" && strings.Contains(query, "UserId=eq."+appleUserId):
+				fmt.Fprintf(w, `[{"UserId":%q,"ExternalUserId":%q}]`, appleUserId, appleSubject)
+			default:
+				fmt.Fprint(w, `[]`)
+			}
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		if fake.failWrites {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fake.writes = append(fake.writes, strings.TrimSpace(fmt.Sprintf("%s %s %s", r.Method, table, body)))
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (f *fakeSupabase) takeWrites() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	writes := f.writes
+	f.writes = nil
+	return writes
+}
+
+func (f *fakeSupabase) setFailWrites(fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failWrites = fail
+}
+
+type appleNotificationTest struct {
+	provider        *testProvider
+	supabase        *fakeSupabase
+	revocationStore revocation.Store
+	router          *gin.Engine
+}
+
+func newAppleNotificationTest(t *testing.T) *appleNotificationTest {
+	gin.SetMode(gin.TestMode)
+
+	provider := newTestProvider(t)
+	provider.addRSAKey(t, "apple")
+	fake := newFakeSupabase(t)
+
+	supabaseClient := superbase.NewSupabaseClient(config.SuperbaseConfig{
+		Url:                   fake.URL,
+		AccountTableName:      "accounts",
+		UsageTableName:        "usage",
+		EventTableName:        "events",
+		DataKeyTableName:      "data_keys",
+		RefreshTokenTableName: "refresh_tokens",
+		IdentityTableName:     "identities",
+		SessionTableName:      "sessions",
+	})
+	userVault, err := vault.NewVault("test master secret", supabaseClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	llmLimiter, err := util.NewConcurrencyLimiter(config.LlmConcurrencyConfig{MaxInFlight: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocationStore := revocation.NewMemoryStore()
+
+	handler := NewAccountHandler(
+		config.SignInWithAppleConfig{AppleClientId: testClientId, JwksUrl: provider.server.URL, JwksRefreshInSec: 3600},
+		config.GoogleConfig{}, config.MagicLinkConfig{}, config.GuestsConfig{}, mailer.NewLogSender(),
+		supabaseClient, userVault, vectorindex.NewRetriever(config.OpenAIConfig{}, supabaseClient, userVault, llmLimiter),
+		config.TokensConfig{AccessTokenTtlInSec: 3600}, revocationStore, revocation.NewSessionSet(supabaseClient, time.Hour),
+		nil, ratelimit.NewMemoryStore(), idempotency.NewMemoryStore())
+
+	router := gin.New()
+	router.POST("/apple/notifications", handler.AppleNotification)
+
+	return &appleNotificationTest{
+		provider:        provider,
+		supabase:        fake,
+		revocationStore: revocationStore,
+		router:          router,
+	}
+}
+
+// notification returns the claims of a notification Apple just sent about the
+// subject
+func notification(jti string, eventType string, subject string) jwt.MapClaims {
+	event, _ := json.Marshal(map[string]string{
+		"type":  eventType,
+		"sub":   subject,
+		"email": "Relay@privaterelay.appleid.com",
+	})
+	return jwt.MapClaims{
+		"iss":    appleIssuer,
+		"aud":    testClientId,
+		"iat":    time.Now().Unix(),
+		"jti":    jti,
+		"events": string(event),
+	}
+}
+
+func (test *appleNotificationTest) post(t *testing.T, payload string) int {
+	body, _ := json.Marshal(map[string]string{"payload": payload})
+	req := httptest.NewRequest(http.MethodPost, "/apple/notifications", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	test.router.ServeHTTP(recorder, req)
+	return recorder.Code
+}
+
+func TestAppleNotificationEvents(t *testing.T) {
+	tests := []struct {
+		eventType string
+		subject   string
+		// prefixes of the writes expected, in order
+		wantWrites []string
+	}{
+		{appleEventEmailEnabled, appleSubject, []string{
+			`PATCH accounts {"Email":"relay@privaterelay.appleid.com"}`,
+		}},
+		{appleEventEmailDisabled, appleSubject, []string{
+			`PATCH accounts {"Email":""}`,
+		}},
+		{appleEventConsentRevoked, appleSubject, []string{
+			`PATCH accounts {"DisabledAt":"`,
+			`DELETE refresh_tokens`,
+			`PATCH sessions {"RevokedAt":"`,
+			`PATCH accounts {"AppleRefreshToken":""}`,
+		}},
+		{appleEventAccountDelete, appleSubject, []string{
+			`PATCH accounts {"AppleRefreshToken":""}`,
+			`DELETE refresh_tokens`,
+			`DELETE sessions`,
+			`DELETE identities`,
+			`DELETE events`,
+			`DELETE usage`,
+			`DELETE data_keys`,
+			`DELETE accounts`,
+		}},
+		{appleEventAccountDelete, "unknown-subject", nil},
+		{"unknown-event", appleSubject, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.eventType+" "+test.subject, func(t *testing.T) {
+			notificationTest := newAppleNotificationTest(t)
+			payload := notificationTest.provider.sign(t, "apple", notification("jti-1", test.eventType, test.subject))
+
+			if code := notificationTest.post(t, payload); code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", code)
+			}
+			writes := notificationTest.supabase.takeWrites()
+			if len(writes) != len(test.wantWrites) {
+				t.Fatalf("writes = %q, want %q", writes, test.wantWrites)
+			}
+			for i, write := range writes {
+				if !strings.HasPrefix(write, test.wantWrites[i]) {
+					t.Errorf("write %d = %q, want prefix %q", i, write, test.wantWrites[i])
+				}
+			}
+
+			revoked, _ := notificationTest.revocationStore.IsRevoked(appleUserId, time.Now().Add(-time.Minute))
+			wantRevoked := test.subject == appleSubject &&
+				(test.eventType == appleEventConsentRevoked || test.eventType == appleEventAccountDelete)
+			if revoked != wantRevoked {
+				t.Errorf("access tokens revoked = %v, want %v", revoked, wantRevoked)
+			}
+		})
+	}
+}
+
+func TestAppleNotificationRejected(t *testing.T) {
+	notificationTest := newAppleNotificationTest(t)
+	provider := notificationTest.provider
+
+	other := newTestProvider(t)
+	other.addRSAKey(t, "apple")
+
+	withClaim := func(key string, value interface{}) jwt.MapClaims {
+		claims := notification("jti-1", appleEventAccountDelete, appleSubject)
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name     string
+		payload  string
+		wantCode int
+	}{
+		{"tampered", provider.sign(t, "apple", withClaim("jti", "jti-1")) + "x", http.StatusUnauthorized},
+		{"unpublished key", other.sign(t, "apple", withClaim("jti", "jti-1")), http.StatusUnauthorized},
+		{"wrong issuer", provider.sign(t, "apple", withClaim("iss", "https://evil.example.com")), http.StatusUnauthorized},
+		{"wrong audience", provider.sign(t, "apple", withClaim("aud", "com.example.other")), http.StatusUnauthorized},
+		{"stale", provider.sign(t, "apple", withClaim("iat", time.Now().Add(-appleNotificationMaxAge-time.Minute).Unix())), http.StatusUnauthorized},
+		{"issued in the future", provider.sign(t, "apple", withClaim("iat", time.Now().Add(appleNotificationMaxAge+time.Minute).Unix())), http.StatusUnauthorized},
+		{"no iat", provider.sign(t, "apple", withClaim("iat", nil)), http.StatusUnauthorized},
+		{"no jti", provider.sign(t, "apple", withClaim("jti", nil)), http.StatusUnauthorized},
+		{"no event", provider.sign(t, "apple", withClaim("events", nil)), http.StatusBadRequest},
+		{"not a token", "not-a-token", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := notificationTest.post(t, test.payload); code != test.wantCode {
+				t.Errorf("status = %d, want %d", code, test.wantCode)
+			}
+			if writes := notificationTest.supabase.takeWrites(); len(writes) != 0 {
+				t.Errorf("rejected notification wrote %q", writes)
+			}
+		})
+	}
+}
+
+func TestAppleNotificationReplay(t *testing.T) {
+	notificationTest := newAppleNotificationTest(t)
+	payload := notificationTest.provider.sign(t, "apple", notification("jti-1", appleEventEmailDisabled, appleSubject))
+
+	if code := notificationTest.post(t, payload); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	notificationTest.supabase.takeWrites()
+
+	if code := notificationTest.post(t, payload); code != http.StatusOK {
+		t.Fatalf("replayed status = %d, want 200", code)
+	}
+	if writes := notificationTest.supabase.takeWrites(); len(writes) != 0 {
+		t.Errorf("replayed notification wrote %q", writes)
+	}
+}
+
+func TestAppleNotificationRetriedAfterFailure(t *testing.T) {
+	notificationTest := newAppleNotificationTest(t)
+	payload := notificationTest.provider.sign(t, "apple", notification("jti-1", appleEventEmailDisabled, appleSubject))
+
+	notificationTest.supabase.setFailWrites(true)
+	if code := notificationTest.post(t, payload); code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", code)
+	}
+
+	// Apple delivers the notification again
+	notificationTest.supabase.setFailWrites(false)
+	if code := notificationTest.post(t, payload); code != http.StatusOK {
+		t.Fatalf("redelivered status = %d, want 200", code)
+	}
+	if writes := notificationTest.supabase.takeWrites(); len(writes) != 1 {
+		t.Errorf("redelivered notification wrote %q, want the email update", writes)
+	}
+}
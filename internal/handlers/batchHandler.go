@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/ratelimit"
+	"github.com/timemachine-app/timemachine-be/util"
+)
+
+const (
+	inputFormBatchItems = "timemachine-batch-items"
+)
+
+// BatchItem describes one event of a batch. Photo names the multipart file
+// part holding the item's photo.
+type BatchItem struct {
+	Date    string `json:"date"`
+	Message string `json:"message"`
+	Photo   string `json:"photo"`
+}
+
+// BatchItemResult is the outcome of one item. Status is the HTTP status the
+// item would have had on its own; items refused with 503 while the server is
+// busy can be sent again.
+type BatchItemResult struct {
+	Index  int                    `json:"index"`
+	Status int                    `json:"status"`
+	Event  map[string]interface{} `json:"event,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+const batchItemBusyError = "server busy, retry the item"
+
+type BatchHandler struct {
+	eventHandler *EventHandler
+	batchConfig  config.BatchConfig
+	quota        *util.RateLimiter
+}
+
+func NewBatchHandler(
+	eventHandler *EventHandler, batchConfig config.BatchConfig, rateLimitStore ratelimit.Store) *BatchHandler {
+	return &BatchHandler{
+		eventHandler: eventHandler,
+		batchConfig:  batchConfig,
+		quota:        util.NewRateLimiter("batch", batchConfig.Quota, rateLimitStore),
+	}
+}
+
+// ProcessBatch processes many events of one multipart request with bounded
+// concurrency. Every item counts against the batch quota instead of the
+// request rate limit, which the request counts against once like any other,
+// and fails or succeeds on its own.
+func (h *BatchHandler) ProcessBatch(c *gin.Context) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	var items []BatchItem
+	if err := json.Unmarshal([]byte(c.PostForm(inputFormBatchItems)), &items); err != nil ||
+		len(items) == 0 || len(items) > h.batchConfig.MaxItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	if quota := h.quota.Allow(util.ClientIdentifier(c), len(items)); !quota.Allowed {
+		// the quota's headers replace the request limit's
+		util.SetRateLimitHeaders(c, quota)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "batch quota exceeded"})
+		return
+	}
+
+	timelineSummary := c.PostForm(inputFormPrevTimelineSummary)
+	userId := c.GetString(util.UserIdContextKey)
+	maxConcurrency := h.batchConfig.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+
+	results := make([]BatchItemResult, len(items))
+	semaphore := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+		wg.Add(1)
+		go func(i int, item BatchItem) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			results[i] = h.processItem(c.Request.Context(), i, item, userId, timelineSummary, form)
+		}(i, item)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+func (h *BatchHandler) processItem(
+	ctx context.Context, index int, item BatchItem, userId string, timelineSummary string, form *multipart.Form) BatchItemResult {
+	if item.Date == "" {
+		return BatchItemResult{Index: index, Status: http.StatusBadRequest, Error: genericBadRequestError}
+	}
+
+	input := EventInput{
+		UserId:          userId,
+		TimelineSummary: timelineSummary,
+		EventTime:       item.Date,
+		Message:         item.Message,
+	}
+	if item.Photo != "" {
+		// photos are only read once the item's turn comes to keep memory bounded
+		imageBytes, err := readFormFile(form, item.Photo)
+		if err != nil {
+			return BatchItemResult{Index: index, Status: http.StatusBadRequest, Error: genericBadRequestError}
+		}
+		input.ImageBytes = &imageBytes
+	}
+
+	// every item's call takes its own slot of the LLM limiter
+	event, err := h.eventHandler.processEvent(ctx, input)
+	if errors.Is(err, util.ErrSaturated) {
+		return BatchItemResult{Index: index, Status: http.StatusServiceUnavailable, Error: batchItemBusyError}
+	}
+	if err != nil {
+		return BatchItemResult{Index: index, Status: http.StatusInternalServerError, Error: genericProcessingError}
+	}
+	if err := h.eventHandler.storeProcessedEvent(ctx, input, event); err != nil {
+		return BatchItemResult{Index: index, Status: http.StatusInternalServerError, Error: genericProcessingError}
+	}
+	return BatchItemResult{Index: index, Status: http.StatusOK, Event: event}
+}
+
+func readFormFile(form *multipart.Form, name string) ([]byte, error) {
+	files := form.File[name]
+	if len(files) == 0 {
+		return nil, fmt.Errorf("missing file part %q", name)
+	}
+
+	file, err := files[0].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
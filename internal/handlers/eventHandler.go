@@ -1,17 +1,26 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/timemachine-app/timemachine-be/daterange"
 	"github.com/timemachine-app/timemachine-be/gemini"
 	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/jobs"
 	"github.com/timemachine-app/timemachine-be/openai"
+	"github.com/timemachine-app/timemachine-be/searchsession"
+	"github.com/timemachine-app/timemachine-be/superbase"
 	"github.com/timemachine-app/timemachine-be/util"
+	"github.com/timemachine-app/timemachine-be/vault"
+	"github.com/timemachine-app/timemachine-be/vectorindex"
 )
 
 const (
@@ -21,55 +30,85 @@ const (
 	inputFormPrevTimelineEvents  = "timemachine-prev-timeline-events"
 	inputFormPrevTimelineSummary = "timeline-summary"
 
-	inputFormHistory    = "timemachine-history"
-	inputFormSearchText = "timemachine-search-text"
+	inputFormHistory       = "timemachine-history"
+	inputFormSearchText    = "timemachine-search-text"
+	inputFormTimezone      = "timemachine-timezone"
+	inputFormCurrentTime   = "timemachine-current-time"
+	inputFormSearchSession = "timemachine-search-session"
+
+	// key of the stored event's id in the response of a processed event
+	storedEventIdKey = "eventId"
 
 	genericProcessingError = "Failed to process your request"
 	genericBadRequestError = "Bad Input Request"
 )
 
 type EventHandler struct {
-	openAIConfig config.OpenAIConfig
-	geminiConfig config.GeminiConfig
-	eventPrompts config.EventPromptsConfig
+	openAIConfig   config.OpenAIConfig
+	geminiConfig   config.GeminiConfig
+	eventPrompts   config.EventPromptsConfig
+	searchConfig   config.SearchConfig
+	supabaseClient *superbase.SupabaseClient
+	vault          *vault.Vault
+	retriever      *vectorindex.Retriever
+	sessionStore   searchsession.Store
+	jobPool        *jobs.Pool
+	llmLimiter     *util.ConcurrencyLimiter
 }
 
-func NewEventHandler(openAIConfig config.OpenAIConfig, geminiConfig config.GeminiConfig, eventPrompts config.EventPromptsConfig) *EventHandler {
+func NewEventHandler(
+	openAIConfig config.OpenAIConfig,
+	geminiConfig config.GeminiConfig,
+	eventPrompts config.EventPromptsConfig,
+	searchConfig config.SearchConfig,
+	supabaseClient *superbase.SupabaseClient,
+	vault *vault.Vault,
+	retriever *vectorindex.Retriever,
+	sessionStore searchsession.Store,
+	jobPool *jobs.Pool,
+	llmLimiter *util.ConcurrencyLimiter) *EventHandler {
 	return &EventHandler{
-		openAIConfig: openAIConfig,
-		geminiConfig: geminiConfig,
-		eventPrompts: eventPrompts,
+		openAIConfig:   openAIConfig,
+		geminiConfig:   geminiConfig,
+		eventPrompts:   eventPrompts,
+		searchConfig:   searchConfig,
+		supabaseClient: supabaseClient,
+		vault:          vault,
+		retriever:      retriever,
+		sessionStore:   sessionStore,
+		jobPool:        jobPool,
+		llmLimiter:     llmLimiter,
 	}
 }
 
+// EventInput is everything needed to process a single event
+type EventInput struct {
+	// stores the processed event for the user when set
+	UserId          string
+	TimelineSummary string
+	EventTime       string
+	Message         string
+	ImageBytes      *[]byte
+	// stores the event under this id when set, so it isn't added twice
+	EventId string
+}
+
+// ProcessEvent processes the event right away, or with ?async=true queues it
+// and returns a job id to poll on /jobs/{id}. Only authenticated users can
+// queue events, an IP is shared by too many clients to own a job.
 func (h *EventHandler) ProcessEvent(c *gin.Context) {
-	contextPrompt := ""
-	timelineSummary := c.PostForm(inputFormPrevTimelineSummary)
-	if timelineSummary != "" {
-		contextPrompt = fmt.Sprintf("%s: %s. ", h.eventPrompts.EventContextTimelineDetailsPrompt, timelineSummary)
+	input := EventInput{
+		UserId:          c.GetString(util.UserIdContextKey),
+		TimelineSummary: c.PostForm(inputFormPrevTimelineSummary),
+		EventTime:       c.PostForm(inputFormDate),
+		Message:         c.PostForm(inputFormMessageKey),
 	}
-
-	eventTime := c.PostForm(inputFormDate)
-	if eventTime == "" {
+	if input.EventTime == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
 		return
 	}
-	contextPrompt = contextPrompt + fmt.Sprintf("%s: %s. ", h.eventPrompts.EventContextTimePrompt, eventTime)
-
-	eventMessage := c.PostForm(inputFormMessageKey)
-	if eventMessage != "" {
-		contextPrompt = contextPrompt +
-			fmt.Sprintf("%s: %s. ", h.eventPrompts.EventContextInputMessagePrompt, eventMessage)
-	}
-
-	// previousEvents := c.PostForm(inputFormPrevTimelineEvents)
-	// if previousEvents != "" {
-	// 	contextPrompt = contextPrompt +
-	// 		fmt.Sprintf("%s: %s. ", h.eventPrompts.EventContextPrevTimelinePrompt, previousEvents)
-	// }
 
 	// Handle file input
-	var imageBytes *[]byte = nil
 	file, _, err := c.Request.FormFile(inputFormPhotoKey)
 	if err == nil {
 		defer file.Close()
@@ -79,9 +118,159 @@ func (h *EventHandler) ProcessEvent(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
 			return
 		}
-		imageBytes = &currentImageBytes
+		input.ImageBytes = &currentImageBytes
+	}
+
+	if c.Query("async") == "true" {
+		userId, ok := authenticatedUserId(c)
+		if !ok {
+			return
+		}
+		payload, err := h.sealJobPayload(userId, input)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			return
+		}
+		job, err := h.jobPool.Submit(userId, payload)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": genericProcessingError})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"jobId":  job.Id,
+			"status": job.Status,
+		})
+		return
+	}
+
+	jsonData, err := h.processEvent(c.Request.Context(), input)
+	if err != nil {
+		respondLlmError(c, h.llmLimiter, err)
+		return
+	}
+	if err := h.storeProcessedEvent(c.Request.Context(), input, jsonData); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	// Return the JSON data as a response
+	c.JSON(http.StatusOK, jsonData)
+}
+
+// sealedJob is the payload or result of a job encrypted with its user's data
+// key, so queued images and processed events aren't kept in the queue in
+// plaintext
+type sealedJob struct {
+	UserId string `json:"userId"`
+	Sealed string `json:"sealed"`
+}
+
+func (h *EventHandler) sealJobPayload(userId string, input EventInput) (json.RawMessage, error) {
+	inputJson, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	return sealJob(h.vault, userId, inputJson)
+}
+
+// ProcessEventJob is the job processor for events queued with ?async=true
+func (h *EventHandler) ProcessEventJob(jobId string, payload json.RawMessage) (json.RawMessage, error) {
+	userId, inputJson, err := openJob(h.vault, payload)
+	if err != nil {
+		return nil, err
+	}
+	var input EventInput
+	if err := json.Unmarshal(inputJson, &input); err != nil {
+		return nil, err
+	}
+	// a retried job stores its event under the same id instead of adding it
+	// again
+	input.EventId = jobEventId(jobId)
+
+	// queued jobs wait for a free slot instead of failing their attempt
+	ctx := util.WaitForSlot(context.Background())
+	jsonData, err := h.processEvent(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.storeProcessedEvent(ctx, input, jsonData); err != nil {
+		return nil, err
+	}
+	result, err := json.Marshal(jsonData)
+	if err != nil {
+		return nil, err
+	}
+	return sealJob(h.vault, userId, result)
+}
+
+// jobEventId formats the 128 bit hex job id as a UUID
+func jobEventId(jobId string) string {
+	if len(jobId) != 32 {
+		return jobId
+	}
+	return fmt.Sprintf("%s-%s-%s-%s-%s", jobId[0:8], jobId[8:12], jobId[12:16], jobId[16:20], jobId[20:])
+}
+
+func sealJob(vault *vault.Vault, userId string, plaintext []byte) (json.RawMessage, error) {
+	sealed, err := vault.Encrypt(userId, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealedJob{UserId: userId, Sealed: sealed})
+}
+
+func openJob(vault *vault.Vault, data json.RawMessage) (string, []byte, error) {
+	var job sealedJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return "", nil, err
+	}
+	plaintext, err := vault.Decrypt(job.UserId, job.Sealed)
+	if err != nil {
+		return "", nil, err
+	}
+	return job.UserId, plaintext, nil
+}
+
+// storeProcessedEvent stores and embeds the processed event of an
+// authenticated user, so it can be searched, and adds its eventId to the
+// response
+func (h *EventHandler) storeProcessedEvent(ctx context.Context, input EventInput, jsonData map[string]interface{}) error {
+	if input.UserId == "" {
+		return nil
+	}
+
+	event, err := json.Marshal(jsonData)
+	if err != nil {
+		return err
+	}
+	storedEvent, err := storeEvent(ctx, h.supabaseClient, h.vault, h.retriever, input.UserId, input.EventId, input.EventTime, event)
+	if err != nil {
+		return err
 	}
 
+	jsonData[storedEventIdKey] = storedEvent.EventId
+	return nil
+}
+
+func (h *EventHandler) processEvent(ctx context.Context, input EventInput) (map[string]interface{}, error) {
+	contextPrompt := ""
+	if input.TimelineSummary != "" {
+		contextPrompt = fmt.Sprintf("%s: %s. ", h.eventPrompts.EventContextTimelineDetailsPrompt, input.TimelineSummary)
+	}
+
+	contextPrompt = contextPrompt + fmt.Sprintf("%s: %s. ", h.eventPrompts.EventContextTimePrompt, input.EventTime)
+
+	if input.Message != "" {
+		contextPrompt = contextPrompt +
+			fmt.Sprintf("%s: %s. ", h.eventPrompts.EventContextInputMessagePrompt, input.Message)
+	}
+
+	// previousEvents := c.PostForm(inputFormPrevTimelineEvents)
+	// if previousEvents != "" {
+	// 	contextPrompt = contextPrompt +
+	// 		fmt.Sprintf("%s: %s. ", h.eventPrompts.EventContextPrevTimelinePrompt, previousEvents)
+	// }
+
 	// response, err := openai.CallOpenAIAPI(
 	// 	contextPrompt, &imageBytes,
 	// 	h.eventPrompts.EventContextSystemInstructionPrompt,
@@ -90,15 +279,18 @@ func (h *EventHandler) ProcessEvent(c *gin.Context) {
 	// 	h.openAIConfig.Model,
 	// 	h.openAIConfig.MaxTokens)
 
-	response, err := gemini.CallGeminiAPI(
-		contextPrompt, imageBytes,
-		h.eventPrompts.EventContextSystemInstructionPrompt,
-		h.eventPrompts.EventContextSystemResponsePrompt,
-		h.geminiConfig.Key,
-		h.geminiConfig.Model)
+	var response string
+	err := h.llmLimiter.Call(ctx, func() (err error) {
+		response, err = gemini.CallGeminiAPI(
+			contextPrompt, input.ImageBytes,
+			h.eventPrompts.EventContextSystemInstructionPrompt,
+			h.eventPrompts.EventContextSystemResponsePrompt,
+			h.geminiConfig.Key,
+			h.geminiConfig.Model)
+		return err
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
-		return
+		return nil, err
 	}
 
 	// clean json
@@ -106,42 +298,120 @@ func (h *EventHandler) ProcessEvent(c *gin.Context) {
 
 	var jsonData map[string]interface{}
 	if err := json.Unmarshal([]byte(cleanResponse), &jsonData); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
-		return
+		return nil, err
 	}
 
-	// Return the JSON data as a response
-	c.JSON(http.StatusOK, jsonData)
+	return jsonData, nil
 }
 
-func (h *EventHandler) Search(c *gin.Context) {
-	contextPrompt := ""
-	inputFormHistory := c.PostForm(inputFormHistory)
-	if inputFormHistory == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+// respondLlmError answers a request whose LLM call failed, with 503 when no
+// slot of the LLM limiter was free
+func respondLlmError(c *gin.Context, llmLimiter *util.ConcurrencyLimiter, err error) {
+	if errors.Is(err, util.ErrSaturated) {
+		llmLimiter.RespondBusy(c)
 		return
 	}
-	contextPrompt = fmt.Sprintf("%s: %s. ", h.eventPrompts.SearchContextHistoryPrompt, inputFormHistory)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+}
 
+// Search answers the search text from the history sent by the client or, when
+// no history is sent, from the stored events most similar to the search text.
+// Date expressions in the search text are resolved against the client's clock
+// and narrow the history down before the LLM sees it. Follow-up questions of a
+// search session are rewritten into standalone queries first.
+func (h *EventHandler) Search(c *gin.Context) {
 	searchText := c.PostForm(inputFormSearchText)
 	if searchText == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
 		return
 	}
-	contextPrompt = contextPrompt + fmt.Sprintf("%s: %s. ", h.eventPrompts.SearchContextSearchTextPrompt, searchText)
 
-	response, err := openai.CallOpenAIAPI(
-		contextPrompt, nil,
-		h.eventPrompts.SearchContextSystemInstructionPrompt,
-		h.eventPrompts.SearchContextSystemResponsePrompt,
-		h.openAIConfig.Key,
-		h.openAIConfig.Model,
-		h.openAIConfig.MaxTokens)
+	now, err := searchClock(c.PostForm(inputFormTimezone), c.PostForm(inputFormCurrentTime))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	userId := c.GetString(util.UserIdContextKey)
+	owner := userId
+	if owner == "" {
+		owner = c.ClientIP()
+	}
+	session, err := h.loadSearchSession(c.PostForm(inputFormSearchSession), owner, userId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
 		return
 	}
 
+	query, err := h.standaloneQuery(c.Request.Context(), session, searchText)
+	if err != nil {
+		respondLlmError(c, h.llmLimiter, err)
+		return
+	}
+	ranges := daterange.Resolve(query, now)
+
+	history := c.PostForm(inputFormHistory)
+	var retrievedEvents []vectorindex.RetrievedEvent
+	if history == "" {
+		if userId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+			return
+		}
+
+		retrievedEvents, err = h.retriever.Retrieve(c.Request.Context(), userId, query, h.searchConfig.TopK, eventTimeFilter(ranges, now.Location()))
+		if err != nil {
+			respondLlmError(c, h.llmLimiter, err)
+			return
+		}
+		previousEvents, err := h.retriever.Fetch(userId, session.LastEventIds())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			return
+		}
+		retrievedEvents = mergeRetrievedEvents(retrievedEvents, previousEvents)
+
+		retrievedHistory, err := json.Marshal(retrievedEvents)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			return
+		}
+		history = string(retrievedHistory)
+	}
+
+	history = filterHistoryByRanges(history, ranges, now.Location())
+	knownEventIds := historyEventIds(history)
+
+	history, err = h.reduceHistory(c.Request.Context(), history, query)
+	if errors.Is(err, errHistoryTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("history is larger than %d chunks", h.searchConfig.MaxChunks)})
+		return
+	}
+	if err != nil {
+		respondLlmError(c, h.llmLimiter, err)
+		return
+	}
+
+	contextPrompt := fmt.Sprintf("%s: %s. ", h.eventPrompts.SearchContextHistoryPrompt, history)
+	contextPrompt = contextPrompt + fmt.Sprintf("%s: %s. ", h.eventPrompts.SearchContextCurrentTimePrompt, now.Format(time.RFC3339))
+	contextPrompt = contextPrompt + fmt.Sprintf("%s: %s. ", h.eventPrompts.SearchContextSearchTextPrompt, query)
+
+	var response string
+	err = h.llmLimiter.Call(c.Request.Context(), func() (err error) {
+		response, err = openai.CallOpenAIAPI(
+			contextPrompt, nil,
+			h.eventPrompts.SearchContextSystemInstructionPrompt,
+			h.eventPrompts.SearchContextSystemResponsePrompt,
+			h.openAIConfig.Key,
+			h.openAIConfig.Model,
+			h.openAIConfig.MaxTokens)
+		return err
+	})
+	if err != nil {
+		respondLlmError(c, h.llmLimiter, err)
+		return
+	}
+
 	// clean json
 	cleanResponse := util.CleanLLMJson(response)
 
@@ -151,6 +421,28 @@ func (h *EventHandler) Search(c *gin.Context) {
 		return
 	}
 
+	// drop any cited event the model made up
+	citations := sanitizeCitations(jsonData[searchCitationsKey], knownEventIds)
+	jsonData[searchCitationsKey] = citations
+	jsonData[searchResolvedRangesKey] = toResolvedRanges(ranges)
+
+	answer, err := json.Marshal(jsonData)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+	session.AddTurn(searchsession.Turn{
+		Query:           searchText,
+		StandaloneQuery: query,
+		Answer:          answer,
+		EventIds:        turnEventIds(citations, retrievedEvents),
+	}, h.searchConfig.SessionMaxTurns)
+	// the answer is still useful when the session can't be kept
+	if err := h.saveSearchSession(session, userId); err == nil {
+		jsonData[searchSessionIdKey] = session.Id
+	}
+	jsonData[searchStandaloneQueryKey] = query
+
 	// Return the JSON data as a response
 	c.JSON(http.StatusOK, jsonData)
 }
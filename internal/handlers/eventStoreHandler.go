@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/timemachine-app/timemachine-be/superbase"
+	"github.com/timemachine-app/timemachine-be/util"
+	"github.com/timemachine-app/timemachine-be/vault"
+	"github.com/timemachine-app/timemachine-be/vectorindex"
+)
+
+const (
+	defaultEventsPageSize = 50
+	maxEventsPageSize     = 200
+)
+
+type StoredEventRequest struct {
+	EventTime string          `json:"eventTime" binding:"required"`
+	Event     json.RawMessage `json:"event" binding:"required"`
+}
+
+type StoredEventResponse struct {
+	EventId   string          `json:"eventId"`
+	EventTime string          `json:"eventTime"`
+	Event     json.RawMessage `json:"event"`
+	CreatedAt string          `json:"createdAt,omitempty"`
+	UpdatedAt string          `json:"updatedAt,omitempty"`
+}
+
+type EventStoreHandler struct {
+	supabaseClient *superbase.SupabaseClient
+	vault          *vault.Vault
+	retriever      *vectorindex.Retriever
+}
+
+func NewEventStoreHandler(supabaseClient *superbase.SupabaseClient, vault *vault.Vault, retriever *vectorindex.Retriever) *EventStoreHandler {
+	return &EventStoreHandler{
+		supabaseClient: supabaseClient,
+		vault:          vault,
+		retriever:      retriever,
+	}
+}
+
+func (h *EventStoreHandler) ListEvents(c *gin.Context) {
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultEventsPageSize)))
+	if err != nil || limit <= 0 || limit > maxEventsPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	storedEvents, err := h.supabaseClient.GetEvents(userId, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	events := []StoredEventResponse{}
+	for _, storedEvent := range storedEvents {
+		event, err := h.decryptEvent(storedEvent)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			return
+		}
+		events = append(events, event)
+	}
+
+	response := gin.H{
+		"events": events,
+		"limit":  limit,
+		"offset": offset,
+	}
+	if len(events) == limit {
+		response["nextOffset"] = offset + limit
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *EventStoreHandler) GetEvent(c *gin.Context) {
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	storedEvent, err := h.supabaseClient.GetEvent(userId, c.Param("id"))
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+
+	event, err := h.decryptEvent(storedEvent)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
+func (h *EventStoreHandler) CreateEvent(c *gin.Context) {
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req StoredEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !validEventTime(req.EventTime) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	storedEvent, err := storeEvent(c.Request.Context(), h.supabaseClient, h.vault, h.retriever, userId, "", req.EventTime, req.Event)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	c.JSON(http.StatusCreated, toStoredEventResponse(storedEvent, req.Event))
+}
+
+func (h *EventStoreHandler) UpdateEvent(c *gin.Context) {
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	var req StoredEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !validEventTime(req.EventTime) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	content, err := h.vault.Encrypt(userId, req.Event)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	vector, embedding, _ := h.retriever.EmbedEvent(c.Request.Context(), userId, req.Event)
+
+	storedEvent, err := h.supabaseClient.UpdateEvent(superbase.StoredEvent{
+		EventId:   c.Param("id"),
+		UserId:    userId,
+		EventTime: req.EventTime,
+		Content:   content,
+		Embedding: embedding,
+	})
+	if err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	h.retriever.Indexed(userId, storedEvent.EventId, storedEvent.EventTime, vector)
+
+	c.JSON(http.StatusOK, toStoredEventResponse(storedEvent, req.Event))
+}
+
+func (h *EventStoreHandler) DeleteEvent(c *gin.Context) {
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	if err := h.supabaseClient.DeleteEvent(userId, c.Param("id")); err != nil {
+		respondStoreError(c, err)
+		return
+	}
+	h.retriever.Removed(userId, c.Param("id"))
+
+	c.JSON(http.StatusOK, gin.H{"success": "true"})
+}
+
+// validEventTime reports whether the event time is RFC 3339, the only format
+// date ranges and aggregates can compare
+func validEventTime(eventTime string) bool {
+	_, err := time.Parse(time.RFC3339, eventTime)
+	return err == nil
+}
+
+// storeEvent encrypts, embeds and stores a new event of the user. Given an
+// event id, storing again replaces the event stored under it.
+func storeEvent(
+	ctx context.Context, supabaseClient *superbase.SupabaseClient, vault *vault.Vault, retriever *vectorindex.Retriever,
+	userId string, eventId string, eventTime string, event []byte) (superbase.StoredEvent, error) {
+	content, err := vault.Encrypt(userId, event)
+	if err != nil {
+		return superbase.StoredEvent{}, err
+	}
+
+	// a failed embedding is backfilled when the user's index is next loaded
+	vector, embedding, _ := retriever.EmbedEvent(ctx, userId, event)
+
+	newEvent := superbase.StoredEvent{
+		EventId:   eventId,
+		UserId:    userId,
+		EventTime: eventTime,
+		Content:   content,
+		Embedding: embedding,
+	}
+	storedEvent, err := supabaseClient.AddEvent(newEvent)
+	if eventId != "" && errors.Is(err, superbase.ErrConflict) {
+		storedEvent, err = supabaseClient.UpdateEvent(newEvent)
+	}
+	if err != nil {
+		return superbase.StoredEvent{}, err
+	}
+	retriever.Indexed(userId, storedEvent.EventId, storedEvent.EventTime, vector)
+
+	return storedEvent, nil
+}
+
+func (h *EventStoreHandler) decryptEvent(storedEvent superbase.StoredEvent) (StoredEventResponse, error) {
+	content, err := h.vault.Decrypt(storedEvent.UserId, storedEvent.Content)
+	if err != nil {
+		return StoredEventResponse{}, err
+	}
+	return toStoredEventResponse(storedEvent, content), nil
+}
+
+func toStoredEventResponse(storedEvent superbase.StoredEvent, content []byte) StoredEventResponse {
+	return StoredEventResponse{
+		EventId:   storedEvent.EventId,
+		EventTime: storedEvent.EventTime,
+		Event:     content,
+		CreatedAt: storedEvent.CreatedAt,
+		UpdatedAt: storedEvent.UpdatedAt,
+	}
+}
+
+func respondStoreError(c *gin.Context, err error) {
+	if errors.Is(err, superbase.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+}
+
+// authenticatedUserId returns the user id set by the validation middleware and
+// responds with 401 when the request isn't authenticated
+func authenticatedUserId(c *gin.Context) (string, bool) {
+	userId := c.GetString(util.UserIdContextKey)
+	if userId == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return "", false
+	}
+	return userId, true
+}
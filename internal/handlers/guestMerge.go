@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/timemachine-app/timemachine-be/superbase"
+)
+
+// mergeGuest moves the stored events and usage of a guest to the user that
+// signed in from the guest's device, then deletes the guest. Each event is
+// re-encrypted with the user's data key and moved in a single update, so a
+// merge that failed halfway is finished by signing in again without copying
+// any event twice.
+func (h *AccountHandler) mergeGuest(guestId string, userId string) error {
+	for {
+		events, err := h.supabaseClient.GetEvents(guestId, 200, 0)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			break
+		}
+
+		for _, event := range events {
+			content, err := h.reencrypt(guestId, userId, event.Content)
+			if err != nil {
+				return err
+			}
+			embedding, err := h.reencrypt(guestId, userId, event.Embedding)
+			if err != nil {
+				return err
+			}
+
+			event.UserId = userId
+			event.Content = content
+			event.Embedding = embedding
+			// moved by a concurrent merge in the meantime
+			if err := h.supabaseClient.MoveEvent(guestId, event); err != nil && !errors.Is(err, superbase.ErrNotFound) {
+				return err
+			}
+		}
+	}
+	// only the local index is dropped, other instances see the moved events
+	// changed and reload the user on their next search
+	h.retriever.Forget(userId)
+
+	if err := h.supabaseClient.ReassignUsageEvents(guestId, userId); err != nil {
+		return err
+	}
+	return h.deleteAccount(guestId)
+}
+
+func (h *AccountHandler) reencrypt(fromUserId string, toUserId string, ciphertext string) (string, error) {
+	if ciphertext == "" {
+		return "", nil
+	}
+	plaintext, err := h.vault.Decrypt(fromUserId, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return h.vault.Encrypt(toUserId, plaintext)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/timemachine-app/timemachine-be/util"
+)
+
+// verifyIdToken checks the signature of an OpenID Connect id token against the
+// provider's key set, that it was issued by one of issuers for one of our
+// client ids and hasn't expired, and returns its claims
+func verifyIdToken(idToken string, jwks *util.JWKS, issuers []string, clientIds []string) (jwt.MapClaims, error) {
+	claims, err := verifyProviderToken(idToken, jwks, issuers, clientIds)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("id token expired")
+	}
+	if subject, _ := claims["sub"].(string); subject == "" {
+		return nil, errors.New("id token without subject")
+	}
+	return claims, nil
+}
+
+// verifyProviderToken checks the signature, issuer and audience of a token
+// signed by a provider, and its expiry if it has one
+func verifyProviderToken(tokenString string, jwks *util.JWKS, issuers []string, clientIds []string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return jwks.Key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if !verifyAny(issuers, func(issuer string) bool { return claims.VerifyIssuer(issuer, true) }) {
+		return nil, errors.New("invalid token issuer")
+	}
+	if !verifyAny(clientIds, func(clientId string) bool { return claims.VerifyAudience(clientId, true) }) {
+		return nil, errors.New("invalid token audience")
+	}
+	return claims, nil
+}
+
+func verifyAny(values []string, verify func(string) bool) bool {
+	for _, value := range values {
+		if value != "" && verify(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// identityFromClaims reads the subject and email of verified id token claims.
+// Apple sends email_verified as a string, Google as a bool.
+func identityFromClaims(provider string, claims jwt.MapClaims) Identity {
+	subject, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+
+	emailVerified := false
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		emailVerified = verified
+	case string:
+		emailVerified = verified == "true"
+	}
+
+	return Identity{
+		Provider:      provider,
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: email != "" && emailVerified,
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/timemachine-app/timemachine-be/util"
+)
+
+const (
+	testIssuer   = "https://provider.example.com"
+	testClientId = "com.example.app"
+)
+
+// testProvider publishes a key set and signs tokens the way an identity
+// provider does
+type testProvider struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	rsaKeys map[string]*rsa.PrivateKey
+	ecKeys  map[string]*ecdsa.PrivateKey
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	provider := &testProvider{
+		rsaKeys: map[string]*rsa.PrivateKey{},
+		ecKeys:  map[string]*ecdsa.PrivateKey{},
+	}
+	provider.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider.mu.Lock()
+		defer provider.mu.Unlock()
+
+		keys := []map[string]string{}
+		for kid, key := range provider.rsaKeys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		for kid, key := range provider.ecKeys {
+			keys = append(keys, map[string]string{
+				"kty": "EC",
+				"kid": kid,
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+func (p *testProvider) jwks() *util.JWKS {
+	return util.NewJWKS(p.server.URL, time.Hour)
+}
+
+func (p *testProvider) addRSAKey(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rsaKeys[kid] = key
+}
+
+func (p *testProvider) addECKey(t *testing.T, kid string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ecKeys[kid] = key
+}
+
+// sign signs the claims with the key of kid, RS256 for RSA keys and ES256 for
+// EC keys
+func (p *testProvider) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var token *jwt.Token
+	var key interface{}
+	if rsaKey, ok := p.rsaKeys[kid]; ok {
+		token, key = jwt.NewWithClaims(jwt.SigningMethodRS256, claims), rsaKey
+	} else if ecKey, ok := p.ecKeys[kid]; ok {
+		token, key = jwt.NewWithClaims(jwt.SigningMethodES256, claims), ecKey
+	} else {
+		t.Fatalf("no key %q", kid)
+	}
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func idTokenClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss": testIssuer,
+		"aud": testClientId,
+		"sub": "provider-user",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestVerifyIdToken(t *testing.T) {
+	provider := newTestProvider(t)
+	provider.addRSAKey(t, "rsa")
+	provider.addECKey(t, "ec")
+
+	withClaim := func(key string, value interface{}) jwt.MapClaims {
+		claims := idTokenClaims()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr bool
+	}{
+		{"valid", func() string { return provider.sign(t, "rsa", idTokenClaims()) }, false},
+		{"second client id", func() string { return provider.sign(t, "rsa", withClaim("aud", "com.example.web")) }, false},
+		{"wrong issuer", func() string { return provider.sign(t, "rsa", withClaim("iss", "https://evil.example.com")) }, true},
+		{"wrong audience", func() string { return provider.sign(t, "rsa", withClaim("aud", "com.example.other")) }, true},
+		{"expired", func() string { return provider.sign(t, "rsa", withClaim("exp", time.Now().Add(-time.Minute).Unix())) }, true},
+		{"no expiry", func() string { return provider.sign(t, "rsa", withClaim("exp", nil)) }, true},
+		{"no subject", func() string { return provider.sign(t, "rsa", withClaim("sub", nil)) }, true},
+		{"ES256 key of the set", func() string { return provider.sign(t, "ec", idTokenClaims()) }, true},
+		{"HS256 with the public key", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, idTokenClaims())
+			token.Header["kid"] = "rsa"
+			signed, _ := token.SignedString(provider.rsaKeys["rsa"].PublicKey.N.Bytes())
+			return signed
+		}, true},
+		{"tampered", func() string { return provider.sign(t, "rsa", idTokenClaims()) + "x" }, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := verifyIdToken(test.token(), provider.jwks(), []string{testIssuer}, []string{testClientId, "com.example.web"})
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("verifyIdToken succeeded with claims %v", claims)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyIdToken: %v", err)
+			}
+			if claims["sub"] != "provider-user" {
+				t.Errorf("sub = %v, want provider-user", claims["sub"])
+			}
+		})
+	}
+}
+
+// refetching the key set for a rotated key is tested in util's jwks tests
+func TestVerifyIdTokenUnknownKid(t *testing.T) {
+	provider := newTestProvider(t)
+	provider.addRSAKey(t, "published")
+	jwks := provider.jwks()
+	if _, err := jwks.Key("published"); err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+
+	// signed with a key the provider doesn't publish
+	other := newTestProvider(t)
+	other.addRSAKey(t, "unpublished")
+	token := other.sign(t, "unpublished", idTokenClaims())
+	if _, err := verifyIdToken(token, jwks, []string{testIssuer}, []string{testClientId}); err == nil {
+		t.Fatal("verifyIdToken with an unpublished key succeeded")
+	}
+}
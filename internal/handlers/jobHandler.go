@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/jobs"
+	"github.com/timemachine-app/timemachine-be/vault"
+)
+
+type JobHandler struct {
+	queue      jobs.Queue
+	jobsConfig config.JobsConfig
+	vault      *vault.Vault
+}
+
+func NewJobHandler(queue jobs.Queue, jobsConfig config.JobsConfig, vault *vault.Vault) *JobHandler {
+	return &JobHandler{
+		queue:      queue,
+		jobsConfig: jobsConfig,
+		vault:      vault,
+	}
+}
+
+// GetJob returns the status of a job and, once it succeeded, its result.
+// Finished jobs expire shortly after they were fetched.
+func (h *JobHandler) GetJob(c *gin.Context) {
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	job, ok, err := h.queue.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+	if !ok || job.Owner != userId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	response := gin.H{
+		"jobId":    job.Id,
+		"status":   job.Status,
+		"attempts": job.Attempts,
+	}
+	switch job.Status {
+	case jobs.StatusSucceeded:
+		_, result, err := openJob(h.vault, job.Result)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			return
+		}
+		response["result"] = json.RawMessage(result)
+	case jobs.StatusDead:
+		response["error"] = genericProcessingError
+	}
+
+	if job.Done() {
+		h.queue.Expire(job.Id, time.Duration(h.jobsConfig.RetrievedTtlInSec)*time.Second)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
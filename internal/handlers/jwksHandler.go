@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timemachine-app/timemachine-be/keyset"
+)
+
+type JwksHandler struct {
+	keyset *keyset.Keyset
+}
+
+func NewJwksHandler(keyset *keyset.Keyset) *JwksHandler {
+	return &JwksHandler{
+		keyset: keyset,
+	}
+}
+
+// GetJwks publishes the public keys access tokens are signed with, so other
+// services can verify them
+func (h *JwksHandler) GetJwks(c *gin.Context) {
+	// verifiers may cache for a fraction of the overlap window
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": h.keyset.JWKS()})
+}
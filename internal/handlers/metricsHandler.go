@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timemachine-app/timemachine-be/util"
+)
+
+type MetricsHandler struct {
+	llmLimiter *util.ConcurrencyLimiter
+}
+
+func NewMetricsHandler(llmLimiter *util.ConcurrencyLimiter) *MetricsHandler {
+	return &MetricsHandler{
+		llmLimiter: llmLimiter,
+	}
+}
+
+// GetMetrics publishes the state of the LLM concurrency limiter. Nothing about
+// the process, such as its command line or memory, is exposed.
+func (h *MetricsHandler) GetMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"llmConcurrency": h.llmLimiter.Stats()})
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+const searchCitationsKey = "citations"
+
+type SearchCitation struct {
+	EventId string  `json:"eventId"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+type historyEvent struct {
+	EventId   string `json:"eventId"`
+	EventTime string `json:"eventTime"`
+}
+
+// historyEventIds returns the ids of the events in a JSON array history
+func historyEventIds(history string) map[string]bool {
+	eventIds := map[string]bool{}
+
+	var events []historyEvent
+	if err := json.Unmarshal([]byte(history), &events); err != nil {
+		return eventIds
+	}
+	for _, event := range events {
+		if event.EventId != "" {
+			eventIds[event.EventId] = true
+		}
+	}
+	return eventIds
+}
+
+// sanitizeCitations keeps the citations the model returned for events that
+// were actually in the history, once per event, ordered by relevance
+func sanitizeCitations(rawCitations interface{}, knownEventIds map[string]bool) []SearchCitation {
+	citations := []SearchCitation{}
+
+	rawBytes, err := json.Marshal(rawCitations)
+	if err != nil {
+		return citations
+	}
+	var candidates []SearchCitation
+	if err := json.Unmarshal(rawBytes, &candidates); err != nil {
+		return citations
+	}
+
+	seen := map[string]bool{}
+	for _, citation := range candidates {
+		citation.EventId = strings.TrimSpace(citation.EventId)
+		if !knownEventIds[citation.EventId] || seen[citation.EventId] {
+			continue
+		}
+		seen[citation.EventId] = true
+
+		if citation.Score < 0 {
+			citation.Score = 0
+		}
+		if citation.Score > 1 {
+			citation.Score = 1
+		}
+		citations = append(citations, citation)
+	}
+
+	sort.SliceStable(citations, func(a, b int) bool {
+		return citations[a].Score > citations[b].Score
+	})
+	return citations
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/timemachine-app/timemachine-be/daterange"
+)
+
+const searchResolvedRangesKey = "resolvedRanges"
+
+type ResolvedRange struct {
+	Expression string `json:"expression"`
+	Start      string `json:"start"`
+	End        string `json:"end"`
+}
+
+// searchClock returns the client's current time in the client's timezone.
+// Missing values fall back to UTC and the server clock.
+func searchClock(timezone string, currentTime string) (time.Time, error) {
+	location := time.UTC
+	if timezone != "" {
+		var err error
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	now := time.Now()
+	if currentTime != "" {
+		var err error
+		now, err = time.Parse(time.RFC3339, currentTime)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return now.In(location), nil
+}
+
+func toResolvedRanges(ranges []daterange.Range) []ResolvedRange {
+	resolved := []ResolvedRange{}
+	for _, r := range ranges {
+		resolved = append(resolved, ResolvedRange{
+			Expression: r.Expression,
+			Start:      r.Start.Format(time.RFC3339),
+			End:        r.End.Format(time.RFC3339),
+		})
+	}
+	return resolved
+}
+
+// filterHistoryByRanges keeps the events of a JSON array history that fall in
+// any of the ranges. Events without a readable eventTime are kept since they
+// can't be ruled out; histories that aren't JSON arrays are left untouched.
+func filterHistoryByRanges(history string, ranges []daterange.Range, location *time.Location) string {
+	if len(ranges) == 0 {
+		return history
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(history), &items); err != nil {
+		return history
+	}
+
+	filtered := []json.RawMessage{}
+	for _, item := range items {
+		var event historyEvent
+		if err := json.Unmarshal(item, &event); err != nil {
+			filtered = append(filtered, item)
+			continue
+		}
+		eventTime, ok := daterange.ParseTime(event.EventTime, location)
+		if !ok || inAnyRange(eventTime, ranges) {
+			filtered = append(filtered, item)
+		}
+	}
+
+	filteredHistory, err := json.Marshal(filtered)
+	if err != nil {
+		return history
+	}
+	return string(filteredHistory)
+}
+
+// eventTimeFilter accepts the event times that fall in any of the ranges, or
+// can't be read, for retrieval to narrow its candidates down before the top k
+// are cut. Without ranges every event is a candidate.
+func eventTimeFilter(ranges []daterange.Range, location *time.Location) func(eventTime string) bool {
+	if len(ranges) == 0 {
+		return nil
+	}
+	return func(eventTime string) bool {
+		t, ok := daterange.ParseTime(eventTime, location)
+		return !ok || inAnyRange(t, ranges)
+	}
+}
+
+func inAnyRange(t time.Time, ranges []daterange.Range) bool {
+	for _, r := range ranges {
+		if r.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/timemachine-app/timemachine-be/openai"
+	"github.com/timemachine-app/timemachine-be/util"
+)
+
+type searchCandidates struct {
+	Candidates []json.RawMessage `json:"candidates"`
+}
+
+// rounds of candidate searches before the candidates are cut to fit
+const maxReduceRounds = 3
+
+// errHistoryTooLarge is returned for a history split into more chunks than
+// search.maxchunks
+var errHistoryTooLarge = errors.New("history too large")
+
+// reduceHistory shrinks a history that doesn't fit in a single search call.
+// Every token-bounded chunk is searched in parallel for candidate matches and
+// the merged candidates are searched again the same way until they fit in the
+// final search call. Candidates that still don't fit after maxReduceRounds, or
+// once a round stops shrinking them, are cut to the first chunk. A history
+// of more than search.maxchunks chunks isn't searched at all.
+func (h *EventHandler) reduceHistory(ctx context.Context, history string, searchText string) (string, error) {
+	for round := 0; ; round++ {
+		chunks := util.ChunkHistory(history, h.searchConfig.ChunkTokens)
+		if len(chunks) == 1 {
+			return history, nil
+		}
+		// later rounds only search fewer candidates
+		if round == 0 && len(chunks) > h.searchConfig.MaxChunks {
+			return "", errHistoryTooLarge
+		}
+		if round == maxReduceRounds {
+			return chunks[0], nil
+		}
+
+		candidates, err := h.searchChunks(ctx, chunks, searchText)
+		if err != nil {
+			return "", err
+		}
+		if util.EstimateTokens(candidates) >= util.EstimateTokens(history) {
+			return util.ChunkHistory(candidates, h.searchConfig.ChunkTokens)[0], nil
+		}
+		history = candidates
+	}
+}
+
+// searchChunks searches every chunk for candidates and merges them in chunk
+// order, so candidates stay in history order. Every chunk's call takes its
+// own slot of the LLM limiter.
+func (h *EventHandler) searchChunks(ctx context.Context, chunks []string, searchText string) (string, error) {
+	maxConcurrency := h.searchConfig.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+
+	results := make([][]json.RawMessage, len(chunks))
+	errs := make([]error, len(chunks))
+	semaphore := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			results[i], errs[i] = h.searchChunk(ctx, chunk, searchText)
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	candidates := []json.RawMessage{}
+	for i := range chunks {
+		if errs[i] != nil {
+			return "", errs[i]
+		}
+		candidates = append(candidates, results[i]...)
+	}
+
+	merged, err := json.Marshal(candidates)
+	if err != nil {
+		return "", err
+	}
+	return string(merged), nil
+}
+
+func (h *EventHandler) searchChunk(ctx context.Context, chunk string, searchText string) ([]json.RawMessage, error) {
+	contextPrompt := fmt.Sprintf("%s: %s. ", h.eventPrompts.SearchContextHistoryPrompt, chunk)
+	contextPrompt = contextPrompt + fmt.Sprintf("%s: %s. ", h.eventPrompts.SearchContextSearchTextPrompt, searchText)
+
+	var response string
+	err := h.llmLimiter.Call(ctx, func() (err error) {
+		response, err = openai.CallOpenAIAPI(
+			contextPrompt, nil,
+			h.eventPrompts.SearchMapContextSystemInstructionPrompt,
+			h.eventPrompts.SearchMapContextSystemResponsePrompt,
+			h.openAIConfig.Key,
+			h.openAIConfig.Model,
+			h.openAIConfig.MaxTokens)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result searchCandidates
+	if err := json.Unmarshal([]byte(util.CleanLLMJson(response)), &result); err != nil {
+		return nil, fmt.Errorf("failed to decode search candidates: %w", err)
+	}
+	return result.Candidates, nil
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/timemachine-app/timemachine-be/openai"
+	"github.com/timemachine-app/timemachine-be/searchsession"
+	"github.com/timemachine-app/timemachine-be/util"
+	"github.com/timemachine-app/timemachine-be/vectorindex"
+)
+
+const (
+	searchSessionIdKey       = "sessionId"
+	searchStandaloneQueryKey = "standaloneQuery"
+)
+
+type standaloneQueryResponse struct {
+	Query string `json:"query"`
+}
+
+// loadSearchSession returns the owner's session with the given id. Unknown,
+// expired or foreign sessions start a new conversation, as do sessions whose
+// turns can no longer be decrypted.
+func (h *EventHandler) loadSearchSession(sessionId string, owner string, userId string) (searchsession.Session, error) {
+	if sessionId != "" {
+		session, ok, err := h.sessionStore.Get(sessionId)
+		if err != nil {
+			return searchsession.Session{}, err
+		}
+		if ok && session.Owner == owner {
+			turns, err := h.openSearchTurns(session, userId)
+			if err == nil {
+				session.Turns = turns
+				session.SealedTurns = ""
+				return session, nil
+			}
+			log.Printf("starting a new search session instead of %s: %v", sessionId, err)
+		}
+	}
+	return searchsession.NewSession(owner)
+}
+
+// saveSearchSession stores the session with its turns encrypted, with the
+// user's data key or, for sessions of signed out clients, the master key
+func (h *EventHandler) saveSearchSession(session searchsession.Session, userId string) error {
+	turns, err := json.Marshal(session.Turns)
+	if err != nil {
+		return err
+	}
+	if userId != "" {
+		session.SealedTurns, err = h.vault.Encrypt(userId, turns)
+	} else {
+		session.SealedTurns, err = h.vault.SealSecret(searchSessionLabel(session.Id), turns)
+	}
+	if err != nil {
+		return err
+	}
+	session.Turns = nil
+
+	return h.sessionStore.Save(session, time.Duration(h.searchConfig.SessionTtlInSec)*time.Second)
+}
+
+func (h *EventHandler) openSearchTurns(session searchsession.Session, userId string) ([]searchsession.Turn, error) {
+	var turnsJson []byte
+	var err error
+	if userId != "" {
+		turnsJson, err = h.vault.Decrypt(userId, session.SealedTurns)
+	} else {
+		turnsJson, err = h.vault.OpenSecret(searchSessionLabel(session.Id), session.SealedTurns)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var turns []searchsession.Turn
+	if err := json.Unmarshal(turnsJson, &turns); err != nil {
+		return nil, fmt.Errorf("failed to decode search session turns: %w", err)
+	}
+	return turns, nil
+}
+
+func searchSessionLabel(sessionId string) string {
+	return "searchsession:" + sessionId
+}
+
+// standaloneQuery rewrites a follow-up question into a query that can be
+// answered without the earlier turns of the conversation
+func (h *EventHandler) standaloneQuery(ctx context.Context, session searchsession.Session, searchText string) (string, error) {
+	if len(session.Turns) == 0 {
+		return searchText, nil
+	}
+
+	conversation, err := json.Marshal(session.Turns)
+	if err != nil {
+		return "", err
+	}
+
+	contextPrompt := fmt.Sprintf("%s: %s. ", h.eventPrompts.SearchRewriteContextConversationPrompt, conversation)
+	contextPrompt = contextPrompt + fmt.Sprintf("%s: %s. ", h.eventPrompts.SearchContextSearchTextPrompt, searchText)
+
+	var response string
+	err = h.llmLimiter.Call(ctx, func() (err error) {
+		response, err = openai.CallOpenAIAPI(
+			contextPrompt, nil,
+			h.eventPrompts.SearchRewriteContextSystemInstructionPrompt,
+			h.eventPrompts.SearchRewriteContextSystemResponsePrompt,
+			h.openAIConfig.Key,
+			h.openAIConfig.Model,
+			h.openAIConfig.MaxTokens)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var rewritten standaloneQueryResponse
+	if err := json.Unmarshal([]byte(util.CleanLLMJson(response)), &rewritten); err != nil {
+		return "", fmt.Errorf("failed to decode standalone query: %w", err)
+	}
+	if strings.TrimSpace(rewritten.Query) == "" {
+		return searchText, nil
+	}
+	return rewritten.Query, nil
+}
+
+// mergeRetrievedEvents appends the events of the previous turn that the new
+// retrieval didn't find again
+func mergeRetrievedEvents(retrieved []vectorindex.RetrievedEvent, previous []vectorindex.RetrievedEvent) []vectorindex.RetrievedEvent {
+	seen := map[string]bool{}
+	for _, event := range retrieved {
+		seen[event.EventId] = true
+	}
+	for _, event := range previous {
+		if !seen[event.EventId] {
+			seen[event.EventId] = true
+			retrieved = append(retrieved, event)
+		}
+	}
+	return retrieved
+}
+
+// turnEventIds returns the cited events followed by the other retrieved ones
+func turnEventIds(citations []SearchCitation, retrieved []vectorindex.RetrievedEvent) []string {
+	seen := map[string]bool{}
+	eventIds := []string{}
+	for _, citation := range citations {
+		if !seen[citation.EventId] {
+			seen[citation.EventId] = true
+			eventIds = append(eventIds, citation.EventId)
+		}
+	}
+	for _, event := range retrieved {
+		if !seen[event.EventId] {
+			seen[event.EventId] = true
+			eventIds = append(eventIds, event.EventId)
+		}
+	}
+	return eventIds
+}
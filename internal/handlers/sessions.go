@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timemachine-app/timemachine-be/superbase"
+	"github.com/timemachine-app/timemachine-be/util"
+)
+
+// headers the app describes the device with when signing in
+const (
+	deviceNameHeader     = "X-Device-Name"
+	devicePlatformHeader = "X-Device-Platform"
+	appVersionHeader     = "X-App-Version"
+
+	maxDeviceFieldLength = 100
+)
+
+type SessionResponse struct {
+	SessionId  string `json:"sessionId"`
+	DeviceName string `json:"deviceName"`
+	Platform   string `json:"platform"`
+	AppVersion string `json:"appVersion"`
+	CreatedAt  string `json:"createdAt"`
+	LastSeenAt string `json:"lastSeenAt"`
+	// whether the request was made with a token of this session
+	Current bool `json:"current"`
+}
+
+// ListSessions returns the signed in devices of the authenticated user
+func (h *AccountHandler) ListSessions(c *gin.Context) {
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	storedSessions, err := h.supabaseClient.GetUserSessions(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	currentSessionId := c.GetString(util.SessionIdContextKey)
+	sessions := []SessionResponse{}
+	for _, session := range storedSessions {
+		sessions = append(sessions, SessionResponse{
+			SessionId:  session.SessionId,
+			DeviceName: session.DeviceName,
+			Platform:   session.Platform,
+			AppVersion: session.AppVersion,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.SessionId == currentSessionId,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSession signs a device of the authenticated user out. Its refresh
+// tokens stop working at once and its access tokens are rejected by every
+// instance after the next reload of revoked sessions.
+func (h *AccountHandler) RevokeSession(c *gin.Context) {
+	userId, ok := authenticatedUserId(c)
+	if !ok {
+		return
+	}
+
+	session, err := h.supabaseClient.GetSession(c.Param("id"))
+	if errors.Is(err, superbase.ErrNotFound) || (err == nil && session.UserId != userId) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	if err := h.revokeSession(session.SessionId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": "true"})
+}
+
+// startSession records a new sign in of the user on the requesting device
+func (h *AccountHandler) startSession(c *gin.Context, userId string) (string, error) {
+	sessionId, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	err = h.supabaseClient.AddSession(superbase.Session{
+		SessionId:  sessionId,
+		UserId:     userId,
+		DeviceName: deviceField(c, deviceNameHeader),
+		Platform:   deviceField(c, devicePlatformHeader),
+		AppVersion: deviceField(c, appVersionHeader),
+		LastSeenAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", err
+	}
+	return sessionId, nil
+}
+
+// revokeSession revokes the refresh tokens of the session and rejects its
+// access tokens
+func (h *AccountHandler) revokeSession(sessionId string) error {
+	if err := h.supabaseClient.RevokeRefreshTokenFamily(sessionId); err != nil {
+		return err
+	}
+	if err := h.supabaseClient.RevokeSession(sessionId); err != nil {
+		return err
+	}
+	h.sessionSet.Add(sessionId)
+	return nil
+}
+
+func deviceField(c *gin.Context, header string) string {
+	value := c.GetHeader(header)
+	if len(value) > maxDeviceFieldLength {
+		value = value[:maxDeviceFieldLength]
+	}
+	return value
+}
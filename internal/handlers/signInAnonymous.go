@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// minDeviceIdLength keeps guessable device ids from being accepted, anyone
+// knowing a device id can sign in as its guest
+const minDeviceIdLength = 32
+
+// anonymousProvider signs devices in as guests. The device id is a random
+// secret generated and kept by the app, only its hash is stored.
+type anonymousProvider struct{}
+
+func newAnonymousProvider() *anonymousProvider {
+	return &anonymousProvider{}
+}
+
+func (p *anonymousProvider) Authenticate(c *gin.Context) (Identity, error) {
+	var req struct {
+		DeviceId string `json:"deviceId"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.DeviceId) < minDeviceIdLength {
+		return Identity{}, errInvalidSignIn
+	}
+
+	return Identity{
+		Provider: providerAnonymous,
+		Subject:  hashToken(req.DeviceId),
+		Guest:    true,
+	}, nil
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/util"
+)
+
+const appleIssuer = "https://appleid.apple.com"
+
+type AppleTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+}
+
+// appleProvider exchanges the authorization code of a sign in with Apple and
+// verifies the id token Apple returns for it
+type appleProvider struct {
+	signInWithAppleConfig config.SignInWithAppleConfig
+	jwks                  *util.JWKS
+}
+
+func newAppleProvider(signInWithAppleConfig config.SignInWithAppleConfig) *appleProvider {
+	jwksRefresh := time.Duration(signInWithAppleConfig.JwksRefreshInSec) * time.Second
+	return &appleProvider{
+		signInWithAppleConfig: signInWithAppleConfig,
+		jwks:                  util.NewJWKS(signInWithAppleConfig.JwksUrl, jwksRefresh),
+	}
+}
+
+func (p *appleProvider) Authenticate(c *gin.Context) (Identity, error) {
+	var req struct {
+		Code string `json:"code"`
+		// raw nonce whose sha256 the client passed to Apple
+		Nonce string `json:"nonce"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" || req.Nonce == "" {
+		return Identity{}, errInvalidSignIn
+	}
+
+	clientSecret, err := generateClientSecret(p.signInWithAppleConfig)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	resp, err := http.PostForm("https://appleid.apple.com/auth/token", url.Values{
+		"client_id":     {p.signInWithAppleConfig.AppleClientId},
+		"client_secret": {clientSecret},
+		"code":          {req.Code},
+		"grant_type":    {"authorization_code"},
+	})
+	if err != nil {
+		return Identity{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// the code is invalid, expired or was already used
+		return Identity{}, errSignInRejected
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var tokenResp AppleTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return Identity{}, err
+	}
+
+	claims, err := verifyIdToken(tokenResp.IDToken, p.jwks,
+		[]string{appleIssuer}, []string{p.signInWithAppleConfig.AppleClientId})
+	if err != nil {
+		return Identity{}, errSignInRejected
+	}
+
+	// the client passes sha256(nonce) to Apple and the raw nonce to us
+	tokenNonce, _ := claims["nonce"].(string)
+	nonceHash := sha256.Sum256([]byte(req.Nonce))
+	if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(hex.EncodeToString(nonceHash[:]))) != 1 {
+		return Identity{}, errSignInRejected
+	}
+
+	identity := identityFromClaims(providerApple, claims)
+	identity.AppleRefreshToken = tokenResp.RefreshToken
+	return identity, nil
+}
+
+func generateClientSecret(singInWithAppleConfig config.SignInWithAppleConfig) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": singInWithAppleConfig.TeamId,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"aud": "https://appleid.apple.com",
+		"sub": singInWithAppleConfig.AppleClientId,
+	})
+	token.Header["kid"] = singInWithAppleConfig.KeyId
+
+	privateKey, err := jwt.ParseECPrivateKeyFromPEM([]byte(singInWithAppleConfig.PrivateKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse apple private key: %w", err)
+	}
+
+	return token.SignedString(privateKey)
+}
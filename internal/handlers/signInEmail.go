@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/mailer"
+	"github.com/timemachine-app/timemachine-be/ratelimit"
+	"github.com/timemachine-app/timemachine-be/superbase"
+	"github.com/timemachine-app/timemachine-be/util"
+)
+
+// emailProvider signs users in with single use links sent to their email
+// address, which also proves the address is theirs
+type emailProvider struct {
+	magicLinkConfig config.MagicLinkConfig
+	sender          mailer.Sender
+	supabaseClient  *superbase.SupabaseClient
+	// links sent to each address, whoever asks for them
+	sendLimit *util.RateLimiter
+}
+
+func newEmailProvider(
+	magicLinkConfig config.MagicLinkConfig, sender mailer.Sender, supabaseClient *superbase.SupabaseClient,
+	rateLimitStore ratelimit.Store) *emailProvider {
+	return &emailProvider{
+		magicLinkConfig: magicLinkConfig,
+		sender:          sender,
+		supabaseClient:  supabaseClient,
+		sendLimit:       util.NewRateLimiter("magiclink", magicLinkConfig.SendLimit, rateLimitStore),
+	}
+}
+
+func (p *emailProvider) Authenticate(c *gin.Context) (Identity, error) {
+	var req struct {
+		Token string `json:"token"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		return Identity{}, errInvalidSignIn
+	}
+
+	link, err := p.supabaseClient.UseMagicLink(hashToken(req.Token))
+	if errors.Is(err, superbase.ErrNotFound) {
+		return Identity{}, errSignInRejected
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, link.ExpiresAt)
+	if err != nil || time.Now().After(expiresAt) {
+		return Identity{}, errSignInRejected
+	}
+
+	return Identity{
+		Provider:      providerEmail,
+		Subject:       link.Email,
+		Email:         link.Email,
+		EmailVerified: true,
+	}, nil
+}
+
+// sendLink stores a new sign in token for the address and emails the link
+func (p *emailProvider) sendLink(email string) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Duration(p.magicLinkConfig.TtlInSec) * time.Second
+	err = p.supabaseClient.AddMagicLink(superbase.MagicLink{
+		TokenHash: hashToken(token),
+		Email:     email,
+		ExpiresAt: time.Now().Add(ttl).UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Use this link to sign in, it expires in %d minutes:\n\n%s\n",
+		p.magicLinkConfig.TtlInSec/60, fmt.Sprintf(p.magicLinkConfig.LinkUrl, token))
+	return p.sender.Send(email, p.magicLinkConfig.Subject, body)
+}
+
+// SendMagicLink emails a sign in link. It answers the same whether or not the
+// address has an account, so it can't be used to probe for users. Links to an
+// address are limited on top of the client's rate limit, so nobody can flood
+// a mailbox from many IPs.
+func (h *AccountHandler) SendMagicLink(c *gin.Context) {
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+	address, err := mail.ParseAddress(req.Email)
+	if err != nil || address.Address != req.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	email := strings.ToLower(address.Address)
+	// keyed by hash, the address isn't kept in the rate limit store
+	if limit := h.emailProvider.sendLimit.Allow(hashToken(email), 1); !limit.Allowed {
+		util.SetRateLimitHeaders(c, limit)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many sign in links sent to this address"})
+		return
+	}
+
+	if err := h.emailProvider.sendLink(email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"success": "true"})
+}
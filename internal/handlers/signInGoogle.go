@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/util"
+)
+
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// googleProvider verifies Google id tokens obtained by the client
+type googleProvider struct {
+	googleConfig config.GoogleConfig
+	jwks         *util.JWKS
+}
+
+func newGoogleProvider(googleConfig config.GoogleConfig) *googleProvider {
+	jwksRefresh := time.Duration(googleConfig.JwksRefreshInSec) * time.Second
+	return &googleProvider{
+		googleConfig: googleConfig,
+		jwks:         util.NewJWKS(googleConfig.JwksUrl, jwksRefresh),
+	}
+}
+
+func (p *googleProvider) Authenticate(c *gin.Context) (Identity, error) {
+	var req struct {
+		IdToken string `json:"idToken"`
+		// nonce the client passed to Google, required for the mobile apps
+		Nonce string `json:"nonce"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.IdToken == "" {
+		return Identity{}, errInvalidSignIn
+	}
+
+	claims, err := verifyIdToken(req.IdToken, p.jwks, googleIssuers, p.googleConfig.ClientIds)
+	if err != nil {
+		return Identity{}, errSignInRejected
+	}
+	if !p.nonceMatches(claims, req.Nonce) {
+		return Identity{}, errSignInRejected
+	}
+
+	return identityFromClaims(providerGoogle, claims), nil
+}
+
+// nonceMatches binds the token to the sign in it was requested for. Tokens
+// issued to the mobile apps must carry the nonce the app sent, so a token
+// leaked from another sign in can't be replayed; a nonce sent by any client
+// must match the token's.
+func (p *googleProvider) nonceMatches(claims jwt.MapClaims, nonce string) bool {
+	tokenNonce, _ := claims["nonce"].(string)
+	if nonce == "" {
+		return tokenNonce == "" && !p.isMobileClient(claims)
+	}
+	return subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) == 1
+}
+
+func (p *googleProvider) isMobileClient(claims jwt.MapClaims) bool {
+	for _, clientId := range p.googleConfig.MobileClientIds {
+		if clientId != "" && claims.VerifyAudience(clientId, true) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timemachine-app/timemachine-be/superbase"
+	"github.com/timemachine-app/timemachine-be/util"
+)
+
+const (
+	providerApple     = "apple"
+	providerGoogle    = "google"
+	providerEmail     = "email"
+	providerAnonymous = "anonymous"
+)
+
+var (
+	errInvalidSignIn  = errors.New("invalid sign in request")
+	errSignInRejected = errors.New("sign in rejected")
+)
+
+// Identity is a user as verified by a sign in provider
+type Identity struct {
+	Provider string
+	Subject  string
+	Email    string
+	// only verified addresses are used to link accounts
+	EmailVerified bool
+	// kept to revoke the sign in with Apple when the account is deleted
+	AppleRefreshToken string
+	// signs in as a guest with a tighter quota
+	Guest bool
+}
+
+// SignInProvider verifies the credentials of a sign in request. It returns
+// errInvalidSignIn for malformed requests and errSignInRejected for
+// credentials that don't check out.
+type SignInProvider interface {
+	Authenticate(c *gin.Context) (Identity, error)
+}
+
+// SignIn signs the user in with the provider named in the path and issues a
+// token pair. A guest signing in with a provider keeps its data: the guest
+// becomes the user when the identity is new, and is merged into the user the
+// identity already belongs to otherwise.
+func (h *AccountHandler) SignIn(c *gin.Context) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown sign in provider"})
+		return
+	}
+
+	identity, err := provider.Authenticate(c)
+	if errors.Is(err, errInvalidSignIn) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+	if errors.Is(err, errSignInRejected) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	if identity.Guest && !h.guestAllowed(c, identity) {
+		return
+	}
+
+	guestId := ""
+	if c.GetString(util.UserRoleContextKey) == util.GuestRole && !identity.Guest {
+		guestId = c.GetString(util.UserIdContextKey)
+	}
+
+	user, err := h.userForIdentity(identity, guestId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	if user.DisabledAt != nil {
+		// signing in again after revoking consent enables the account again
+		if err := h.supabaseClient.SetUserDisabled(user.UserId, false); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			return
+		}
+	}
+
+	if identity.AppleRefreshToken != "" {
+		appleRefreshToken, err := h.vault.Encrypt(user.UserId, []byte(identity.AppleRefreshToken))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			return
+		}
+		if err := h.supabaseClient.UpdateUserAppleRefreshToken(user.UserId, appleRefreshToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			return
+		}
+	}
+
+	sessionId, err := h.startSession(c, user.UserId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	tokens, err := h.issueTokens(user, sessionId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate JWT token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// guestAllowed reports whether the device may sign in as a guest, new guests
+// are limited per client IP. Otherwise it writes the error response.
+func (h *AccountHandler) guestAllowed(c *gin.Context, identity Identity) bool {
+	_, err := h.supabaseClient.GetUserIdentity(identity.Provider, identity.Subject)
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, superbase.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return false
+	}
+
+	if limit := h.guestLimit.Allow(c.ClientIP(), 1); !limit.Allowed {
+		util.SetRateLimitHeaders(c, limit)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many guests signed in from this address"})
+		return false
+	}
+	return true
+}
+
+// userForIdentity returns the user the identity is linked to. A new identity
+// is linked to the user with the same verified email, to the signed in guest,
+// or to a new user. When a concurrent sign in of the same identity links it
+// first, the user created for this one is deleted and the linked user
+// returned.
+func (h *AccountHandler) userForIdentity(identity Identity, guestId string) (superbase.User, error) {
+	linked, err := h.supabaseClient.GetUserIdentity(identity.Provider, identity.Subject)
+	if err == nil {
+		return h.mergedUser(linked.UserId, guestId)
+	}
+	if !errors.Is(err, superbase.ErrNotFound) {
+		return superbase.User{}, err
+	}
+
+	email := ""
+	if identity.EmailVerified {
+		email = strings.ToLower(identity.Email)
+	}
+
+	created := false
+	user, err := h.existingUser(identity, email)
+	switch {
+	case err == nil:
+		user, err = h.mergedUser(user.UserId, guestId)
+	case errors.Is(err, superbase.ErrNotFound) && guestId != "":
+		err = h.supabaseClient.UpgradeGuestUser(guestId, email, externalUserId(identity))
+		if err == nil {
+			user, err = h.mergedUser(guestId, guestId)
+		}
+	case errors.Is(err, superbase.ErrNotFound):
+		role := ""
+		if identity.Guest {
+			role = util.GuestRole
+		}
+		user, err = h.supabaseClient.AddUser(superbase.User{
+			Email:          email,
+			ExternalUserId: externalUserId(identity),
+			Role:           role,
+		})
+		created = err == nil
+	}
+	if err != nil {
+		return superbase.User{}, err
+	}
+
+	err = h.supabaseClient.AddUserIdentity(superbase.UserIdentity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		UserId:   user.UserId,
+		Email:    email,
+	})
+	if errors.Is(err, superbase.ErrConflict) {
+		if created {
+			if err := h.supabaseClient.DeleteUser(user.UserId); err != nil {
+				log.Printf("failed to delete user %s left without identity by a concurrent sign in: %v", user.UserId, err)
+			}
+		}
+		linked, err := h.supabaseClient.GetUserIdentity(identity.Provider, identity.Subject)
+		if err != nil {
+			return superbase.User{}, err
+		}
+		return h.mergedUser(linked.UserId, guestId)
+	}
+	if err != nil {
+		return superbase.User{}, err
+	}
+	return user, nil
+}
+
+// mergedUser returns the user after merging the guest into it, if any. A
+// guest that became the user loses its device id, which must not keep signing
+// in to the upgraded account. It is removed only after the upgrade, so a
+// failed upgrade leaves the guest usable and signing in again finishes it.
+func (h *AccountHandler) mergedUser(userId string, guestId string) (superbase.User, error) {
+	switch {
+	case guestId == "":
+	case guestId != userId:
+		if err := h.mergeGuest(guestId, userId); err != nil {
+			return superbase.User{}, err
+		}
+	default:
+		if err := h.supabaseClient.DeleteUserProviderIdentities(userId, providerAnonymous); err != nil {
+			return superbase.User{}, err
+		}
+	}
+	return h.supabaseClient.GetUserById(userId)
+}
+
+func (h *AccountHandler) existingUser(identity Identity, email string) (superbase.User, error) {
+	// accounts created before identities were recorded are keyed by their Apple subject
+	if identity.Provider == providerApple {
+		user, err := h.supabaseClient.GetUser(identity.Subject)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, superbase.ErrNotFound) {
+			return superbase.User{}, err
+		}
+	}
+
+	if email == "" {
+		return superbase.User{}, superbase.ErrNotFound
+	}
+	return h.supabaseClient.GetUserByEmail(email)
+}
+
+// externalUserId keeps Apple subjects as they were stored before other
+// providers existed and prefixes the others so they can't collide
+func externalUserId(identity Identity) string {
+	if identity.Provider == providerApple {
+		return identity.Subject
+	}
+	return identity.Provider + ":" + identity.Subject
+}
+
+// isAppleSubject reports whether an external user id is an Apple subject,
+// stored without the provider prefix of the other providers
+func isAppleSubject(externalUserId string) bool {
+	return externalUserId != "" && !strings.Contains(externalUserId, ":")
+}
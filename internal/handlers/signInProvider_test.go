@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/superbase"
+)
+
+// A concurrent sign in of the same identity links it between this sign in's
+// lookup and its insert, so both created a user
+func TestUserForIdentityLinkedConcurrently(t *testing.T) {
+	const subject = "google-subject"
+
+	var mu sync.Mutex
+	linked := false
+	var deletes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		table := strings.TrimPrefix(r.URL.Path, "/rest/v1/")
+		query, _ := url.QueryUnescape(r.URL.RawQuery)
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodGet && table == "identities":
+			if linked {
+				fmt.Fprintf(w, `[{"Provider":"google","Subject":%q,"UserId":"winner"}]`, subject)
+				return
+			}
+			fmt.Fprint(w, `[]`)
+		case r.Method == http.MethodGet && table == "accounts" && query == "UserId=eq.winner":
+			fmt.Fprint(w, `[{"UserId":"winner"}]`)
+		case r.Method == http.MethodGet:
+			fmt.Fprint(w, `[]`)
+		case r.Method == http.MethodPost && table == "accounts":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `[{"UserId":"loser"}]`)
+		case r.Method == http.MethodPost && table == "identities":
+			// the other sign in inserted the identity first
+			linked = true
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"code":"23505"}`)
+		case r.Method == http.MethodDelete:
+			deletes = append(deletes, table+"?"+query)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	handler := &AccountHandler{
+		supabaseClient: superbase.NewSupabaseClient(config.SuperbaseConfig{
+			Url:               server.URL,
+			AccountTableName:  "accounts",
+			IdentityTableName: "identities",
+		}),
+	}
+
+	user, err := handler.userForIdentity(Identity{Provider: providerGoogle, Subject: subject}, "")
+	if err != nil {
+		t.Fatalf("userForIdentity: %v", err)
+	}
+	if user.UserId != "winner" {
+		t.Errorf("user = %q, want the user the identity was linked to", user.UserId)
+	}
+	if want := []string{"accounts?UserId=eq.loser"}; !reflect.DeepEqual(deletes, want) {
+		t.Errorf("deletes = %v, want %v", deletes, want)
+	}
+}
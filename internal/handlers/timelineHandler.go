@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/openai"
+	"github.com/timemachine-app/timemachine-be/util"
+)
+
+const (
+	inputFormTimelineRange  = "timemachine-timeline-range"
+	inputFormTimelineEvents = "timemachine-timeline-events"
+	// JSON array of the highlights of the previous summary
+	inputFormPrevTimelineHighlights = "timeline-highlights"
+
+	// number of events folded into the rolling summary per LLM call
+	timelineSummaryBatchSize = 50
+	// highlights kept across summaries, the newest win
+	maxTimelineHighlights = 10
+)
+
+var timelineRanges = map[string]bool{
+	"day":  true,
+	"week": true,
+	"trip": true,
+}
+
+type TimelineSummary struct {
+	Summary    string   `json:"summary"`
+	Highlights []string `json:"highlights"`
+}
+
+type TimelineHandler struct {
+	openAIConfig    config.OpenAIConfig
+	timelinePrompts config.TimelinePromptsConfig
+	timelineConfig  config.TimelineConfig
+	llmLimiter      *util.ConcurrencyLimiter
+}
+
+func NewTimelineHandler(
+	openAIConfig config.OpenAIConfig, timelinePrompts config.TimelinePromptsConfig,
+	timelineConfig config.TimelineConfig, llmLimiter *util.ConcurrencyLimiter) *TimelineHandler {
+	return &TimelineHandler{
+		openAIConfig:    openAIConfig,
+		timelinePrompts: timelinePrompts,
+		timelineConfig:  timelineConfig,
+		llmLimiter:      llmLimiter,
+	}
+}
+
+// Summary builds a rolling narrative summary for a day, week or trip. When a
+// previous summary and its highlights are supplied only the new events need
+// to be sent and the summary is updated incrementally.
+func (h *TimelineHandler) Summary(c *gin.Context) {
+	timelineRange := c.PostForm(inputFormTimelineRange)
+	if !timelineRanges[timelineRange] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	events := c.PostForm(inputFormTimelineEvents)
+	if events == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	batches, count := splitTimelineEvents(events, timelineSummaryBatchSize)
+	if count > h.timelineConfig.MaxEvents {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("at most %d events can be summarized at once", h.timelineConfig.MaxEvents)})
+		return
+	}
+
+	summary := TimelineSummary{
+		Summary: c.PostForm(inputFormPrevTimelineSummary),
+	}
+	if highlights := c.PostForm(inputFormPrevTimelineHighlights); highlights != "" {
+		if err := json.Unmarshal([]byte(highlights), &summary.Highlights); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+			return
+		}
+		summary.Highlights = mergeHighlights(summary.Highlights, nil)
+	}
+	for _, batch := range batches {
+		next, err := h.summarize(c.Request.Context(), timelineRange, summary, batch)
+		if err != nil {
+			respondLlmError(c, h.llmLimiter, err)
+			return
+		}
+		summary = next
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+func (h *TimelineHandler) summarize(ctx context.Context, timelineRange string, prev TimelineSummary, events string) (TimelineSummary, error) {
+	contextPrompt := fmt.Sprintf("%s: %s. ", h.timelinePrompts.SummaryContextRangePrompt, timelineRange)
+	if prev.Summary != "" {
+		contextPrompt = contextPrompt +
+			fmt.Sprintf("%s: %s. ", h.timelinePrompts.SummaryContextPrevSummaryPrompt, prev.Summary)
+	}
+	contextPrompt = contextPrompt + fmt.Sprintf("%s: %s. ", h.timelinePrompts.SummaryContextEventsPrompt, events)
+
+	var response string
+	err := h.llmLimiter.Call(ctx, func() (err error) {
+		response, err = openai.CallOpenAIAPI(
+			contextPrompt, nil,
+			h.timelinePrompts.SummaryContextSystemInstructionPrompt,
+			h.timelinePrompts.SummaryContextSystemResponsePrompt,
+			h.openAIConfig.Key,
+			h.openAIConfig.Model,
+			h.openAIConfig.MaxTokens)
+		return err
+	})
+	if err != nil {
+		return TimelineSummary{}, err
+	}
+
+	var summary TimelineSummary
+	if err := json.Unmarshal([]byte(util.CleanLLMJson(response)), &summary); err != nil {
+		return TimelineSummary{}, fmt.Errorf("failed to decode summary: %w", err)
+	}
+	if strings.TrimSpace(summary.Summary) == "" {
+		return TimelineSummary{}, fmt.Errorf("empty summary")
+	}
+
+	// keep highlights from earlier batches the model didn't repeat
+	summary.Highlights = mergeHighlights(prev.Highlights, summary.Highlights)
+
+	return summary, nil
+}
+
+// splitTimelineEvents splits a JSON array of events into batches of at most
+// batchSize events and returns them with the number of events. Anything that
+// isn't a JSON array is sent as a single batch and counts as one event.
+func splitTimelineEvents(events string, batchSize int) ([]string, int) {
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(events), &items); err != nil {
+		return []string{events}, 1
+	}
+	if len(items) <= batchSize {
+		return []string{events}, len(items)
+	}
+
+	var batches []string
+	for start := 0; start < len(items); start += batchSize {
+		end := start + batchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		batch, _ := json.Marshal(items[start:end])
+		batches = append(batches, string(batch))
+	}
+	return batches, len(items)
+}
+
+// mergeHighlights puts the new highlights before the previous ones and drops
+// the oldest beyond maxTimelineHighlights, so a rolling summary doesn't grow
+// with every update
+func mergeHighlights(prev, next []string) []string {
+	seen := map[string]bool{}
+	merged := []string{}
+	for _, highlight := range append(next, prev...) {
+		if len(merged) == maxTimelineHighlights {
+			break
+		}
+		if highlight == "" || seen[highlight] {
+			continue
+		}
+		seen[highlight] = true
+		merged = append(merged, highlight)
+	}
+	return merged
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timemachine-app/timemachine-be/superbase"
+)
+
+// TokenPair is a short lived access token and the refresh token to renew it
+type TokenPair struct {
+	AccessToken  string `json:"jwt_token"`
+	RefreshToken string `json:"refresh_token"`
+	// lifetime of the access token in seconds
+	ExpiresIn int    `json:"expires_in"`
+	UserId    string `json:"userId"`
+}
+
+// RefreshToken exchanges a refresh token for a new token pair. Every refresh
+// token works once; presenting a used one means it leaked, so the whole family
+// descending from that sign in is revoked.
+func (h *AccountHandler) RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": genericBadRequestError})
+		return
+	}
+
+	tokenHash := hashToken(req.RefreshToken)
+	stored, err := h.supabaseClient.GetRefreshToken(tokenHash)
+	if errors.Is(err, superbase.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	if stored.RevokedAt != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	expiresAt, err := time.Parse(time.RFC3339, stored.ExpiresAt)
+	if err != nil || time.Now().After(expiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	fresh := stored.UsedAt == nil
+	if fresh {
+		fresh, err = h.supabaseClient.UseRefreshToken(tokenHash)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			return
+		}
+	}
+	if !fresh {
+		if err := h.revokeSession(stored.FamilyId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	// load the user again so the new access token carries its current role
+	user, err := h.supabaseClient.GetUserById(stored.UserId)
+	if errors.Is(err, superbase.ErrNotFound) || (err == nil && user.DisabledAt != nil) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+
+	tokens, err := h.issueTokens(user, stored.FamilyId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": genericProcessingError})
+		return
+	}
+	// refreshes are when a session is seen, the access tokens in between
+	// aren't tracked
+	if err := h.supabaseClient.TouchSession(stored.FamilyId); err != nil {
+		log.Printf("failed to update last seen of session: %v", err)
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// issueTokens creates an access token for the session and stores a new
+// refresh token in its family
+func (h *AccountHandler) issueTokens(user superbase.User, sessionId string) (TokenPair, error) {
+	accessTtl := time.Duration(h.tokensConfig.AccessTokenTtlInSec) * time.Second
+	accessToken, err := GenerateJWTToken(user, sessionId, h.keyset, accessTtl)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshTtl := time.Duration(h.tokensConfig.RefreshTokenTtlInSec) * time.Second
+	err = h.supabaseClient.AddRefreshToken(superbase.RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		FamilyId:  sessionId,
+		UserId:    user.UserId,
+		ExpiresAt: time.Now().Add(refreshTtl).UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    h.tokensConfig.AccessTokenTtlInSec,
+		UserId:       user.UserId,
+	}, nil
+}
+
+func randomToken(size int) (string, error) {
+	tokenBytes := make([]byte, size)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+// hashToken is how refresh tokens and magic links are looked up, the tokens
+// themselves are never stored
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
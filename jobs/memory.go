@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryJob struct {
+	job       Job
+	expiresAt time.Time
+}
+
+// MemoryQueue keeps jobs in process memory. Jobs don't survive a restart, so
+// reaping has nothing to do.
+type MemoryQueue struct {
+	mu    sync.Mutex
+	jobs  map[string]memoryJob
+	ready chan string
+}
+
+func NewMemoryQueue(capacity int) *MemoryQueue {
+	return &MemoryQueue{
+		jobs:  make(map[string]memoryJob),
+		ready: make(chan string, capacity),
+	}
+}
+
+func (m *MemoryQueue) Enqueue(job Job) error {
+	m.mu.Lock()
+	now := time.Now()
+	for id, stored := range m.jobs {
+		if !stored.expiresAt.IsZero() && now.After(stored.expiresAt) {
+			delete(m.jobs, id)
+		}
+	}
+	m.jobs[job.Id] = memoryJob{job: job}
+	m.mu.Unlock()
+
+	select {
+	case m.ready <- job.Id:
+		return nil
+	default:
+		m.mu.Lock()
+		delete(m.jobs, job.Id)
+		m.mu.Unlock()
+		return ErrQueueFull
+	}
+}
+
+func (m *MemoryQueue) Dequeue(ctx context.Context) (Job, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		case id := <-m.ready:
+			job, ok, _ := m.Get(id)
+			if ok {
+				return job, nil
+			}
+		}
+	}
+}
+
+func (m *MemoryQueue) Save(job Job, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := memoryJob{job: job}
+	if ttl > 0 {
+		stored.expiresAt = time.Now().Add(ttl)
+	}
+	m.jobs[job.Id] = stored
+	return nil
+}
+
+func (m *MemoryQueue) Get(id string) (Job, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.jobs[id]
+	if !ok || (!stored.expiresAt.IsZero() && time.Now().After(stored.expiresAt)) {
+		return Job{}, false, nil
+	}
+	return stored.job, true, nil
+}
+
+func (m *MemoryQueue) Expire(id string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.jobs[id]; ok {
+		stored.expiresAt = time.Now().Add(ttl)
+		m.jobs[id] = stored
+	}
+	return nil
+}
+
+func (m *MemoryQueue) Requeue(id string) error {
+	select {
+	case m.ready <- id:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (m *MemoryQueue) Ack(id string) error {
+	return nil
+}
+
+func (m *MemoryQueue) Reap(leaseTimeout time.Duration) error {
+	return nil
+}
@@ -0,0 +1,169 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/timemachine-app/timemachine-be/internal/config"
+)
+
+// Processor does the work of a job and returns its result. Every attempt of
+// a job gets the same job id, so side effects can be keyed by it.
+type Processor func(jobId string, payload json.RawMessage) (json.RawMessage, error)
+
+// Pool runs queued jobs on a fixed number of workers. Failed jobs are retried
+// with a linear backoff and dead-lettered after the last attempt.
+type Pool struct {
+	queue      Queue
+	jobsConfig config.JobsConfig
+}
+
+func NewPool(queue Queue, jobsConfig config.JobsConfig) *Pool {
+	return &Pool{
+		queue:      queue,
+		jobsConfig: jobsConfig,
+	}
+}
+
+// Submit enqueues a new job for the owner
+func (p *Pool) Submit(owner string, payload json.RawMessage) (Job, error) {
+	id, err := newJobId()
+	if err != nil {
+		return Job{}, err
+	}
+
+	now := time.Now()
+	job := Job{
+		Id:        id,
+		Owner:     owner,
+		Status:    StatusQueued,
+		Payload:   payload,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := p.queue.Enqueue(job); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// Start runs the workers and the reaper until ctx is done
+func (p *Pool) Start(ctx context.Context, process Processor) {
+	for i := 0; i < p.jobsConfig.Workers; i++ {
+		go p.work(ctx, process)
+	}
+	if p.jobsConfig.LeaseTimeoutInSec > 0 {
+		go p.reap(ctx)
+	}
+}
+
+func (p *Pool) work(ctx context.Context, process Processor) {
+	for {
+		job, err := p.queue.Dequeue(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// backend unavailable, try again shortly
+			time.Sleep(time.Second)
+			continue
+		}
+		p.run(job, process)
+	}
+}
+
+func (p *Pool) run(job Job, process Processor) {
+	job.Status = StatusRunning
+	job.Attempts++
+	job.UpdatedAt = time.Now()
+	if err := p.queue.Save(job, 0); err != nil {
+		p.queue.Requeue(job.Id)
+		return
+	}
+
+	stopRenewing := p.renewLease(job)
+	result, err := process(job.Id, job.Payload)
+	stopRenewing()
+	job.UpdatedAt = time.Now()
+	if err == nil {
+		job.Status = StatusSucceeded
+		job.Result = result
+		job.Error = ""
+		p.finish(job)
+		return
+	}
+
+	job.Error = err.Error()
+	if job.Attempts >= p.jobsConfig.MaxAttempts {
+		job.Status = StatusDead
+		p.finish(job)
+		return
+	}
+
+	job.Status = StatusQueued
+	if err := p.queue.Save(job, 0); err != nil {
+		// left in flight, the reaper picks it up after the lease timeout
+		return
+	}
+	backoff := time.Duration(p.jobsConfig.RetryBackoffInSec*job.Attempts) * time.Second
+	time.AfterFunc(backoff, func() {
+		if err := p.queue.Requeue(job.Id); err != nil {
+			job.Status = StatusDead
+			p.finish(job)
+		}
+	})
+}
+
+// renewLease keeps touching the running job so the reaper doesn't requeue a
+// job that is merely slow. The returned func stops renewing and waits for a
+// renewal in progress, so it can't overwrite the job's next state.
+func (p *Pool) renewLease(job Job) func() {
+	if p.jobsConfig.LeaseTimeoutInSec <= 0 {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Duration(p.jobsConfig.LeaseTimeoutInSec) * time.Second / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				job.UpdatedAt = time.Now()
+				p.queue.Save(job, 0)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// finish stores the final state without the payload and releases the job
+func (p *Pool) finish(job Job) {
+	job.Payload = nil
+	p.queue.Save(job, time.Duration(p.jobsConfig.ResultTtlInSec)*time.Second)
+	p.queue.Ack(job.Id)
+}
+
+func (p *Pool) reap(ctx context.Context) {
+	leaseTimeout := time.Duration(p.jobsConfig.LeaseTimeoutInSec) * time.Second
+	ticker := time.NewTicker(leaseTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.queue.Reap(leaseTimeout)
+		}
+	}
+}
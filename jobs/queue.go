@@ -0,0 +1,71 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	// StatusDead is the dead-letter state of jobs that ran out of attempts
+	StatusDead Status = "dead"
+)
+
+var ErrQueueFull = errors.New("job queue is full")
+
+// Job is a unit of background work. Payload is dropped once the job is done
+// so user content doesn't outlive the job.
+type Job struct {
+	Id        string          `json:"id"`
+	Owner     string          `json:"owner"`
+	Status    Status          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// Done reports whether the job reached a final state
+func (j Job) Done() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusDead
+}
+
+// Queue stores jobs and hands queued ones to workers. A dequeued job stays
+// in flight until it is acked or requeued.
+type Queue interface {
+	// Enqueue stores a new job and makes it available to workers
+	Enqueue(job Job) error
+	// Dequeue blocks until a job is available or ctx is done
+	Dequeue(ctx context.Context) (Job, error)
+	// Save stores the job's state, expiring it after ttl when ttl is positive
+	Save(job Job, ttl time.Duration) error
+	// Get returns the job, or false when it doesn't exist or has expired
+	Get(id string) (Job, bool, error)
+	// Expire removes the job after ttl
+	Expire(id string, ttl time.Duration) error
+	// Requeue makes an in-flight job available to workers again
+	Requeue(id string) error
+	// Ack marks an in-flight job as finished
+	Ack(id string) error
+	// Reap requeues in-flight jobs not updated within leaseTimeout, e.g. because
+	// the instance running them went away
+	Reap(leaseTimeout time.Duration) error
+}
+
+func newJobId() (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(idBytes), nil
+}
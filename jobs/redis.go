@@ -0,0 +1,156 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisJobKeyPrefix     = "jobs:job:"
+	redisReadyListKey     = "jobs:ready"
+	redisInFlightListKey  = "jobs:inflight"
+	redisDequeuePollDelay = 5 * time.Second
+)
+
+// RedisQueue keeps jobs in Redis so they survive restarts and are shared
+// between instances. Dequeued jobs move atomically to an in-flight list, from
+// which jobs of instances that went away are reaped.
+type RedisQueue struct {
+	client *redis.Client
+}
+
+func NewRedisQueue(client *redis.Client) *RedisQueue {
+	return &RedisQueue{
+		client: client,
+	}
+}
+
+func (r *RedisQueue) Enqueue(job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	ctx := context.Background()
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisJobKeyPrefix+job.Id, data, 0)
+		pipe.LPush(ctx, redisReadyListKey, job.Id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisQueue) Dequeue(ctx context.Context) (Job, error) {
+	for {
+		id, err := r.client.BLMove(ctx, redisReadyListKey, redisInFlightListKey, "RIGHT", "LEFT", redisDequeuePollDelay).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return Job{}, fmt.Errorf("failed to dequeue job: %w", err)
+		}
+
+		job, ok, err := r.Get(id)
+		if err != nil {
+			return Job{}, err
+		}
+		if !ok {
+			// expired while queued
+			r.Ack(id)
+			continue
+		}
+		return job, nil
+	}
+}
+
+func (r *RedisQueue) Save(job Job, ttl time.Duration) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	if err := r.client.Set(context.Background(), redisJobKeyPrefix+job.Id, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisQueue) Get(id string) (Job, bool, error) {
+	data, err := r.client.Get(context.Background(), redisJobKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, fmt.Errorf("failed to get job: %w", err)
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return Job{}, false, fmt.Errorf("failed to decode job: %w", err)
+	}
+	return job, true, nil
+}
+
+func (r *RedisQueue) Expire(id string, ttl time.Duration) error {
+	if err := r.client.Expire(context.Background(), redisJobKeyPrefix+id, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to expire job: %w", err)
+	}
+	return nil
+}
+
+// requeueScript only moves jobs that are still in flight, so a job requeued
+// by both its worker and the reaper isn't run twice
+var requeueScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], 0, ARGV[1]) > 0 then
+	redis.call('LPUSH', KEYS[2], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+func (r *RedisQueue) Requeue(id string) error {
+	err := requeueScript.Run(context.Background(), r.client, []string{redisInFlightListKey, redisReadyListKey}, id).Err()
+	if err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisQueue) Ack(id string) error {
+	if err := r.client.LRem(context.Background(), redisInFlightListKey, 0, id).Err(); err != nil {
+		return fmt.Errorf("failed to ack job: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisQueue) Reap(leaseTimeout time.Duration) error {
+	ids, err := r.client.LRange(context.Background(), redisInFlightListKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list in-flight jobs: %w", err)
+	}
+
+	for _, id := range ids {
+		job, ok, err := r.Get(id)
+		if err != nil {
+			return err
+		}
+		if !ok || job.Done() {
+			r.Ack(id)
+			continue
+		}
+		if time.Since(job.UpdatedAt) > leaseTimeout {
+			if err := r.Requeue(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
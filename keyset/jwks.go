@@ -0,0 +1,42 @@
+package keyset
+
+import (
+	"encoding/base64"
+)
+
+// JSONWebKey is the public part of a signing key as published in the key set
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// JWKS returns the published public keys
+func (k *Keyset) JWKS() []JSONWebKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make([]JSONWebKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		// coordinates are fixed size big-endian for P-256
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		key.publicKey.X.FillBytes(x)
+		key.publicKey.Y.FillBytes(y)
+
+		keys = append(keys, JSONWebKey{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(x),
+			Y:   base64.RawURLEncoding.EncodeToString(y),
+			Kid: key.kid,
+			Use: "sig",
+			Alg: algorithm,
+		})
+	}
+	return keys
+}
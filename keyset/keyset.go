@@ -0,0 +1,324 @@
+package keyset
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/superbase"
+	"github.com/timemachine-app/timemachine-be/vault"
+)
+
+const algorithm = "ES256"
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type signingKey struct {
+	kid        string
+	slot       int64
+	createdAt  time.Time
+	publicKey  *ecdsa.PublicKey
+	privateKey *ecdsa.PrivateKey
+}
+
+// Keyset signs access tokens with ES256 keys stored in Supabase and shared by
+// every instance. A new key is generated every rotation interval. It is
+// published for the overlap window before it starts signing, so verifiers
+// caching the key set know it in time, and the key it replaces stays
+// published for the overlap window after, until the tokens it signed expired.
+type Keyset struct {
+	signingKeysConfig config.SigningKeysConfig
+	supabaseClient    *superbase.SupabaseClient
+	vault             *vault.Vault
+
+	mu   sync.RWMutex
+	keys []signingKey
+}
+
+// NewKeyset rejects an overlap shorter than the access token lifetime, which
+// would let tokens outlive the publication of the key that signed them
+func NewKeyset(
+	signingKeysConfig config.SigningKeysConfig, accessTokenTtl time.Duration,
+	supabaseClient *superbase.SupabaseClient, vault *vault.Vault) (*Keyset, error) {
+	if time.Duration(signingKeysConfig.OverlapInSec)*time.Second < accessTokenTtl {
+		return nil, fmt.Errorf("signingkeys.overlapinsec %d is shorter than the access token lifetime %v",
+			signingKeysConfig.OverlapInSec, accessTokenTtl)
+	}
+
+	return &Keyset{
+		signingKeysConfig: signingKeysConfig,
+		supabaseClient:    supabaseClient,
+		vault:             vault,
+	}, nil
+}
+
+// Load reads the stored keys, rotating and pruning them when due. Only the
+// instance that stores the key of a rotation first generates it, the others
+// read it back.
+func (k *Keyset) Load() error {
+	keys, err := k.loadKeys()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if k.rotationDue(keys, now) {
+		key, err := k.generateKey(nextSlot(keys))
+		switch {
+		case err == nil:
+			keys = append(keys, key)
+		case errors.Is(err, superbase.ErrConflict):
+			if keys, err = k.loadKeys(); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+
+	live, retired := k.partition(keys, now)
+	for _, key := range retired {
+		// instances race to delete retired keys, losing is harmless
+		if err := k.supabaseClient.DeleteSigningKey(key.kid); err != nil {
+			log.Printf("failed to prune signing key %s: %v", key.kid, err)
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = live
+	k.mu.Unlock()
+	return nil
+}
+
+// rotationDue reports whether the newest key is older than the rotation
+// interval, or there is no key yet
+func (k *Keyset) rotationDue(keys []signingKey, now time.Time) bool {
+	return len(keys) == 0 || now.Sub(keys[len(keys)-1].createdAt) >= k.rotationInterval()
+}
+
+// partition splits the keys, oldest first, into the keys still published and
+// the keys retired for the overlap window after their successor started
+// signing
+func (k *Keyset) partition(keys []signingKey, now time.Time) (live []signingKey, retired []signingKey) {
+	for i, key := range keys {
+		if i+1 < len(keys) && now.After(k.activatesAt(keys[i+1]).Add(k.overlap())) {
+			retired = append(retired, key)
+			continue
+		}
+		live = append(live, key)
+	}
+	return live, retired
+}
+
+// nextSlot is the slot of the key of the next rotation
+func nextSlot(keys []signingKey) int64 {
+	if len(keys) == 0 {
+		return 0
+	}
+	return keys[len(keys)-1].slot + 1
+}
+
+// Start reloads the keys until ctx is done, picking up keys rotated by other
+// instances
+func (k *Keyset) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Duration(k.signingKeysConfig.RefreshInSec) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := k.Load(); err != nil {
+					log.Printf("failed to reload signing keys: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Sign returns the claims as a token signed with the current signing key
+func (k *Keyset) Sign(claims jwt.MapClaims) (string, error) {
+	key, ok := k.signingKey()
+	if !ok {
+		return "", errors.New("no signing key")
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.privateKey)
+}
+
+// Verify checks the token against the published keys and returns its claims
+func (k *Keyset) Verify(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodES256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return k.Key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// Key returns the published public key with the given key id
+func (k *Keyset) Key(kid string) (crypto.PublicKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.kid == kid {
+			return key.publicKey, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// signingKey is the newest key published for the overlap window, or the
+// oldest key when none has been, as on first start
+func (k *Keyset) signingKey() (signingKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.signingKeyAt(k.keys, time.Now())
+}
+
+func (k *Keyset) signingKeyAt(keys []signingKey, now time.Time) (signingKey, bool) {
+	if len(keys) == 0 {
+		return signingKey{}, false
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		if !now.Before(k.activatesAt(keys[i])) {
+			return keys[i], true
+		}
+	}
+	return keys[0], true
+}
+
+func (k *Keyset) activatesAt(key signingKey) time.Time {
+	return key.createdAt.Add(k.overlap())
+}
+
+func (k *Keyset) overlap() time.Duration {
+	return time.Duration(k.signingKeysConfig.OverlapInSec) * time.Second
+}
+
+func (k *Keyset) rotationInterval() time.Duration {
+	return time.Duration(k.signingKeysConfig.RotationIntervalInSec) * time.Second
+}
+
+func (k *Keyset) loadKeys() ([]signingKey, error) {
+	storedKeys, err := k.supabaseClient.GetSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]signingKey, 0, len(storedKeys))
+	for _, storedKey := range storedKeys {
+		if storedKey.Algorithm != algorithm {
+			continue
+		}
+		key, err := k.parseKey(storedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key %s: %w", storedKey.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (k *Keyset) parseKey(storedKey superbase.SigningKey) (signingKey, error) {
+	createdAt, err := time.Parse(time.RFC3339Nano, storedKey.CreatedAt)
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	privateDer, err := k.vault.OpenSecret(storedKey.Kid, storedKey.PrivateKey)
+	if err != nil {
+		return signingKey{}, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(privateDer)
+	if err != nil {
+		return signingKey{}, err
+	}
+	privateKey, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return signingKey{}, errors.New("not an ecdsa key")
+	}
+
+	return signingKey{
+		kid:        storedKey.Kid,
+		slot:       storedKey.Slot,
+		createdAt:  createdAt,
+		publicKey:  &privateKey.PublicKey,
+		privateKey: privateKey,
+	}, nil
+}
+
+func (k *Keyset) generateKey(slot int64) (signingKey, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return signingKey{}, err
+	}
+	kid := hex.EncodeToString(kidBytes)
+
+	privateDer, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return signingKey{}, err
+	}
+	sealed, err := k.vault.SealSecret(kid, privateDer)
+	if err != nil {
+		return signingKey{}, err
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	createdAt := time.Now().UTC()
+	err = k.supabaseClient.AddSigningKey(superbase.SigningKey{
+		Kid:        kid,
+		Algorithm:  algorithm,
+		PublicKey:  base64.StdEncoding.EncodeToString(publicDer),
+		PrivateKey: sealed,
+		CreatedAt:  createdAt.Format(time.RFC3339Nano),
+		Slot:       slot,
+	})
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	return signingKey{
+		kid:        kid,
+		slot:       slot,
+		createdAt:  createdAt,
+		publicKey:  &privateKey.PublicKey,
+		privateKey: privateKey,
+	}, nil
+}
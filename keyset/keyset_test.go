@@ -0,0 +1,142 @@
+package keyset
+
+import (
+	"testing"
+	"time"
+
+	"github.com/timemachine-app/timemachine-be/internal/config"
+)
+
+const (
+	rotationInterval = 30 * 24 * time.Hour
+	overlap          = 24 * time.Hour
+)
+
+var epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func testKeyset() *Keyset {
+	k, err := NewKeyset(config.SigningKeysConfig{
+		RotationIntervalInSec: int(rotationInterval / time.Second),
+		OverlapInSec:          int(overlap / time.Second),
+	}, overlap, nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+func TestNewKeysetRejectsOverlapShorterThanTokens(t *testing.T) {
+	signingKeysConfig := config.SigningKeysConfig{
+		RotationIntervalInSec: int(rotationInterval / time.Second),
+		OverlapInSec:          int(overlap / time.Second),
+	}
+	if _, err := NewKeyset(signingKeysConfig, overlap+time.Second, nil, nil); err == nil {
+		t.Error("NewKeyset with tokens outliving the overlap succeeded")
+	}
+}
+
+// rotatedKeys are keys generated every rotation interval from the epoch
+func rotatedKeys(n int) []signingKey {
+	keys := make([]signingKey, n)
+	for i := range keys {
+		keys[i] = signingKey{
+			kid:       string(rune('a' + i)),
+			slot:      int64(i),
+			createdAt: epoch.Add(time.Duration(i) * rotationInterval),
+		}
+	}
+	return keys
+}
+
+func kids(keys []signingKey) string {
+	kids := ""
+	for _, key := range keys {
+		kids += key.kid
+	}
+	return kids
+}
+
+func TestRotationDue(t *testing.T) {
+	k := testKeyset()
+	tests := []struct {
+		name string
+		keys []signingKey
+		now  time.Time
+		want bool
+	}{
+		{"no key", nil, epoch, true},
+		{"new key", rotatedKeys(1), epoch, false},
+		{"just before the interval", rotatedKeys(1), epoch.Add(rotationInterval - time.Second), false},
+		{"at the interval", rotatedKeys(1), epoch.Add(rotationInterval), true},
+		{"newest key decides", rotatedKeys(2), epoch.Add(rotationInterval + time.Hour), false},
+	}
+	for _, test := range tests {
+		if got := k.rotationDue(test.keys, test.now); got != test.want {
+			t.Errorf("%s: rotationDue = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNextSlot(t *testing.T) {
+	if slot := nextSlot(nil); slot != 0 {
+		t.Errorf("nextSlot(nil) = %d, want 0", slot)
+	}
+	if slot := nextSlot(rotatedKeys(3)); slot != 3 {
+		t.Errorf("nextSlot = %d, want 3", slot)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	k := testKeyset()
+	// b is created at the rotation interval, signs from one overlap later and
+	// a stays published for another overlap
+	bActivates := epoch.Add(rotationInterval + overlap)
+	tests := []struct {
+		name        string
+		keys        []signingKey
+		now         time.Time
+		wantLive    string
+		wantRetired string
+	}{
+		{"single key", rotatedKeys(1), epoch.Add(10 * rotationInterval), "a", ""},
+		{"successor not signing yet", rotatedKeys(2), bActivates.Add(-time.Second), "ab", ""},
+		{"successor signing", rotatedKeys(2), bActivates, "ab", ""},
+		{"end of the overlap", rotatedKeys(2), bActivates.Add(overlap), "ab", ""},
+		{"after the overlap", rotatedKeys(2), bActivates.Add(overlap + time.Second), "b", "a"},
+		{"several retired", rotatedKeys(3), epoch.Add(2*rotationInterval + 2*overlap + time.Second), "c", "ab"},
+		{"newest is never retired", rotatedKeys(3), epoch.Add(100 * rotationInterval), "c", "ab"},
+	}
+	for _, test := range tests {
+		live, retired := k.partition(test.keys, test.now)
+		if kids(live) != test.wantLive || kids(retired) != test.wantRetired {
+			t.Errorf("%s: partition = %q, %q, want %q, %q",
+				test.name, kids(live), kids(retired), test.wantLive, test.wantRetired)
+		}
+	}
+}
+
+func TestSigningKeyAt(t *testing.T) {
+	k := testKeyset()
+	bActivates := epoch.Add(rotationInterval + overlap)
+	tests := []struct {
+		name string
+		keys []signingKey
+		now  time.Time
+		want string
+	}{
+		{"first key before its overlap", rotatedKeys(1), epoch, "a"},
+		{"successor only published", rotatedKeys(2), bActivates.Add(-time.Second), "a"},
+		{"successor activated", rotatedKeys(2), bActivates, "b"},
+		{"newest activated key", rotatedKeys(3), epoch.Add(2*rotationInterval + overlap - time.Second), "b"},
+	}
+	for _, test := range tests {
+		key, ok := k.signingKeyAt(test.keys, test.now)
+		if !ok || key.kid != test.want {
+			t.Errorf("%s: signingKeyAt = %q, %v, want %q", test.name, key.kid, ok, test.want)
+		}
+	}
+
+	if _, ok := k.signingKeyAt(nil, epoch); ok {
+		t.Error("signingKeyAt without keys found a key")
+	}
+}
@@ -0,0 +1,16 @@
+package mailer
+
+import "log"
+
+// LogSender writes emails to the log instead of sending them, a stand-in for
+// local development where no SMTP relay is available
+type LogSender struct{}
+
+func NewLogSender() *LogSender {
+	return &LogSender{}
+}
+
+func (s *LogSender) Send(to string, subject string, body string) error {
+	log.Printf("email to %s: %s\n%s", to, subject, body)
+	return nil
+}
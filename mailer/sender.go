@@ -0,0 +1,6 @@
+package mailer
+
+// Sender delivers plain text emails
+type Sender interface {
+	Send(to string, subject string, body string) error
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+
+	"github.com/timemachine-app/timemachine-be/internal/config"
+)
+
+// SMTPSender sends emails through an SMTP relay
+type SMTPSender struct {
+	smtpConfig config.SmtpConfig
+}
+
+func NewSMTPSender(smtpConfig config.SmtpConfig) *SMTPSender {
+	return &SMTPSender{
+		smtpConfig: smtpConfig,
+	}
+}
+
+func (s *SMTPSender) Send(to string, subject string, body string) error {
+	// header injection through the recipient would let callers add recipients
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("invalid recipient")
+	}
+
+	message := strings.Join([]string{
+		"From: " + s.smtpConfig.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	var auth smtp.Auth
+	if s.smtpConfig.Username != "" {
+		auth = smtp.PlainAuth("", s.smtpConfig.Username, s.smtpConfig.Password, s.smtpConfig.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", s.smtpConfig.Host, s.smtpConfig.Port)
+	if err := smtp.SendMail(addr, auth, s.smtpConfig.From, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
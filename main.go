@@ -1,16 +1,28 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 
+	"github.com/timemachine-app/timemachine-be/idempotency"
 	"github.com/timemachine-app/timemachine-be/internal/config"
 	"github.com/timemachine-app/timemachine-be/internal/handlers"
+	"github.com/timemachine-app/timemachine-be/jobs"
+	"github.com/timemachine-app/timemachine-be/keyset"
+	"github.com/timemachine-app/timemachine-be/mailer"
+	"github.com/timemachine-app/timemachine-be/ratelimit"
+	"github.com/timemachine-app/timemachine-be/revocation"
+	"github.com/timemachine-app/timemachine-be/searchsession"
 	"github.com/timemachine-app/timemachine-be/superbase"
 	"github.com/timemachine-app/timemachine-be/util"
+	"github.com/timemachine-app/timemachine-be/vault"
+	"github.com/timemachine-app/timemachine-be/vectorindex"
 )
 
 func main() {
@@ -27,23 +39,152 @@ func main() {
 
 	// Intialize Superbase
 	superbaseClient := superbase.NewSupabaseClient(config.Clients.Superbase)
+	// Initialize Vault for per-user encryption of stored events
+	userVault, err := vault.NewVault(config.EncryptionKey, superbaseClient)
+	if err != nil {
+		log.Fatalf("Failed to initialize vault: %v", err)
+	}
+	// Initialize Keyset signing access tokens
+	tokenKeyset, err := keyset.NewKeyset(
+		config.SigningKeys, time.Duration(config.Tokens.AccessTokenTtlInSec)*time.Second, superbaseClient, userVault)
+	if err != nil {
+		log.Fatalf("Failed to initialize signing keys: %v", err)
+	}
+	if err := tokenKeyset.Load(); err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	tokenKeyset.Start(context.Background())
+	// Initialize cap on LLM calls in flight, published on /metrics
+	llmLimiter, err := util.NewConcurrencyLimiter(config.LlmConcurrency)
+	if err != nil {
+		log.Fatalf("Failed to load llm concurrency limits: %v", err)
+	}
+	llmSlot := util.ConcurrencyMiddleware(llmLimiter)
+	// Initialize Retriever for semantic search over stored events
+	retriever := vectorindex.NewRetriever(config.Clients.OpenAI, superbaseClient, userVault, llmLimiter)
+
+	// Initialize Redis, only connected when a backend uses it
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     config.Clients.Redis.Addr,
+		Password: config.Clients.Redis.Password,
+		DB:       config.Clients.Redis.DB,
+	})
+
+	// Initialize search session store
+	var sessionStore searchsession.Store = searchsession.NewMemoryStore()
+	if config.Search.SessionBackend == "redis" {
+		sessionStore = searchsession.NewRedisStore(redisClient)
+	}
+
+	// Initialize job queue
+	var jobQueue jobs.Queue = jobs.NewMemoryQueue(config.Jobs.QueueSize)
+	if config.Jobs.Backend == "redis" {
+		jobQueue = jobs.NewRedisQueue(redisClient)
+	}
+	jobPool := jobs.NewPool(jobQueue, config.Jobs)
+
+	// Initialize idempotency store for retried requests
+	var idempotencyStore idempotency.Store = idempotency.NewMemoryStore()
+	if config.Idempotency.Backend == "redis" {
+		idempotencyStore = idempotency.NewRedisStore(redisClient)
+	}
+	idempotent := util.IdempotencyMiddleware(idempotencyStore, userVault, config.Idempotency)
+
+	// Initialize denylist of revoked access tokens. App Engine scales to
+	// several instances, an in-memory denylist would leave the access tokens
+	// of deleted accounts valid on all but one of them.
+	var revocationStore revocation.Store = revocation.NewMemoryStore()
+	if config.Tokens.RevocationBackend == "redis" {
+		revocationStore = revocation.NewRedisStore(redisClient)
+	} else if os.Getenv("GAE_INSTANCE") != "" {
+		log.Fatalf("tokens.revocationbackend must be redis on App Engine")
+	}
+
+	// Initialize set of revoked sessions, reloaded on every instance
+	sessionSet := revocation.NewSessionSet(
+		superbaseClient, time.Duration(config.Tokens.AccessTokenTtlInSec)*time.Second)
+	if err := sessionSet.Load(); err != nil {
+		log.Fatalf("Failed to load revoked sessions: %v", err)
+	}
+	sessionSet.Start(context.Background(), time.Duration(config.Tokens.SessionRefreshInSec)*time.Second)
+
+	// Initialize store counting rate limited requests
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if config.RoutePolicies.Backend == "redis" {
+		rateLimitStore = ratelimit.NewRedisStore(redisClient)
+	}
+
+	// Initialize route policies
+	routePolicies, err := util.NewRoutePolicies(config.RoutePolicies, rateLimitStore)
+	if err != nil {
+		log.Fatalf("Failed to load route policies: %v", err)
+	}
 
 	// Initialize Router
 	router := gin.Default()
 	// Apply the rate limiting middleware
-	router.Use(util.ValidationMiddleware(config.RateLimit, config.JwtSecret, superbaseClient))
+	router.Use(util.ValidationMiddleware(
+		routePolicies, tokenKeyset, superbaseClient, revocationStore, sessionSet))
 	// health handler
 	healthHandler := handlers.NewHealthHandler()
 	router.GET("/health", healthHandler.IsHealthy)
+	// metrics handler
+	metricsHandler := handlers.NewMetricsHandler(llmLimiter)
+	router.GET("/metrics", metricsHandler.GetMetrics)
+	// jwks handler
+	jwksHandler := handlers.NewJwksHandler(tokenKeyset)
+	router.GET("/.well-known/jwks.json", jwksHandler.GetJwks)
+	// Initialize email sender for magic links
+	var sender mailer.Sender = mailer.NewLogSender()
+	if config.Clients.Smtp.Backend == "smtp" {
+		sender = mailer.NewSMTPSender(config.Clients.Smtp)
+	}
+
 	// account handler
-	accountHandler := handlers.NewAccountHandler(config.Clients.SignInWithApple, superbaseClient, config.JwtSecret)
-	router.POST("/signin/apple", accountHandler.SignInWithApple)
-	router.POST("/delete", accountHandler.DeleteAccount)
+	accountHandler := handlers.NewAccountHandler(
+		config.Clients.SignInWithApple, config.Clients.Google, config.MagicLink, config.Guests, sender,
+		superbaseClient, userVault, retriever, config.Tokens, revocationStore, sessionSet, tokenKeyset, rateLimitStore, idempotencyStore)
+	router.POST("/signin/:provider", accountHandler.SignIn)
+	router.POST("/signin/email/link", accountHandler.SendMagicLink)
+	router.POST("/delete", idempotent, accountHandler.DeleteAccount)
+	router.POST("/token/refresh", accountHandler.RefreshToken)
+	router.POST("/apple/notifications", accountHandler.AppleNotification)
+	router.GET("/sessions", accountHandler.ListSessions)
+	router.DELETE("/sessions/:id", accountHandler.RevokeSession)
 
 	// event handler
-	eventHandler := handlers.NewEventHandler(config.Clients.OpenAI, config.Clients.Gemini, config.Prompts.EventPrompts)
-	router.POST("/event", eventHandler.ProcessEvent)
-	router.POST("/search", eventHandler.Search)
+	eventHandler := handlers.NewEventHandler(
+		config.Clients.OpenAI, config.Clients.Gemini, config.Prompts.EventPrompts, config.Search,
+		superbaseClient, userVault, retriever, sessionStore, jobPool, llmLimiter)
+	jobPool.Start(context.Background(), eventHandler.ProcessEventJob)
+	router.POST("/event", llmSlot, idempotent, eventHandler.ProcessEvent)
+	router.POST("/search", llmSlot, eventHandler.Search)
+
+	// job handler
+	jobHandler := handlers.NewJobHandler(jobQueue, config.Jobs, userVault)
+	router.GET("/jobs/:id", jobHandler.GetJob)
+
+	// batch handler
+	batchHandler := handlers.NewBatchHandler(eventHandler, config.Batch, rateLimitStore)
+	router.POST("/events/batch", llmSlot, batchHandler.ProcessBatch)
+
+	// aggregate handler
+	aggregateHandler := handlers.NewAggregateHandler(
+		config.Clients.OpenAI, config.Prompts.EventPrompts, superbaseClient, userVault, llmLimiter)
+	router.POST("/search/aggregate", llmSlot, aggregateHandler.Aggregate)
+
+	// event store handler
+	eventStoreHandler := handlers.NewEventStoreHandler(superbaseClient, userVault, retriever)
+	router.GET("/events", eventStoreHandler.ListEvents)
+	router.POST("/events", eventStoreHandler.CreateEvent)
+	router.GET("/events/:id", eventStoreHandler.GetEvent)
+	router.PUT("/events/:id", eventStoreHandler.UpdateEvent)
+	router.DELETE("/events/:id", eventStoreHandler.DeleteEvent)
+
+	// timeline handler
+	timelineHandler := handlers.NewTimelineHandler(
+		config.Clients.OpenAI, config.Prompts.TimelinePrompts, config.Timeline, llmLimiter)
+	router.POST("/timeline/summary", llmSlot, timelineHandler.Summary)
 
 	// setPortAndRun starts router on a server port
 	router.Run(fmt.Sprintf(":%d", config.Server.Port))
@@ -0,0 +1,71 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	embeddingsEndpoint = "https://api.openai.com/v1/embeddings"
+)
+
+// EmbeddingPayload represents the payload sent to the OpenAI embeddings API
+type EmbeddingPayload struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+// EmbeddingResponse represents the structure of the response from the OpenAI embeddings API
+type EmbeddingResponse struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// CallEmbeddingAPI returns the embedding vector of the input text
+func CallEmbeddingAPI(input string, apiKey string, model string) ([]float32, error) {
+	payloadBytes, err := json.Marshal(EmbeddingPayload{
+		Model: model,
+		Input: input,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling payload: %w", err)
+	}
+
+	request, err := http.NewRequest("POST", embeddingsEndpoint, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+apiKey)
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer response.Body.Close()
+
+	responseData, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding request failed, status code: %d, response: %s", response.StatusCode, string(responseData))
+	}
+
+	var embeddingResponse EmbeddingResponse
+	if err := json.Unmarshal(responseData, &embeddingResponse); err != nil {
+		return nil, fmt.Errorf("error unmarshalling response: %w", err)
+	}
+
+	if len(embeddingResponse.Data) == 0 {
+		return nil, fmt.Errorf("no embedding in the response")
+	}
+
+	return embeddingResponse.Data[0].Embedding, nil
+}
@@ -0,0 +1,110 @@
+package querydsl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/timemachine-app/timemachine-be/daterange"
+)
+
+type FieldType string
+
+const (
+	FieldTypeString FieldType = "string"
+	FieldTypeNumber FieldType = "number"
+	FieldTypeTime   FieldType = "time"
+	FieldTypeBool   FieldType = "bool"
+	FieldTypeList   FieldType = "list"
+)
+
+// Event is a history event flattened into dot separated field paths, e.g.
+// "event.place.name"
+type Event struct {
+	Id     string
+	Fields map[string]interface{}
+}
+
+// ParseEvents reads a JSON array history into events
+func ParseEvents(history string) ([]Event, error) {
+	var items []map[string]interface{}
+	if err := json.Unmarshal([]byte(history), &items); err != nil {
+		return nil, fmt.Errorf("history is not a JSON array of events: %w", err)
+	}
+
+	events := make([]Event, 0, len(items))
+	for _, item := range items {
+		fields := map[string]interface{}{}
+		flatten("", item, fields)
+		id, _ := fields["eventId"].(string)
+		events = append(events, Event{
+			Id:     id,
+			Fields: fields,
+		})
+	}
+	return events, nil
+}
+
+func flatten(prefix string, value map[string]interface{}, fields map[string]interface{}) {
+	for key, fieldValue := range value {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if nested, ok := fieldValue.(map[string]interface{}); ok {
+			flatten(path, nested, fields)
+			continue
+		}
+		if fieldValue != nil {
+			fields[path] = fieldValue
+		}
+	}
+}
+
+// Fields returns the type of every field found in the events. Strings that
+// all read as timestamps are time fields; fields with mixed types are strings.
+func Fields(events []Event, location *time.Location) map[string]FieldType {
+	fields := map[string]FieldType{}
+	for _, event := range events {
+		for path, value := range event.Fields {
+			valueType := typeOf(value, location)
+			if existing, ok := fields[path]; ok && existing != valueType {
+				valueType = FieldTypeString
+			}
+			fields[path] = valueType
+		}
+	}
+	return fields
+}
+
+// DescribeFields lists the fields and their types for the LLM prompt
+func DescribeFields(fields map[string]FieldType) string {
+	paths := make([]string, 0, len(fields))
+	for path := range fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	description := ""
+	for _, path := range paths {
+		description = description + fmt.Sprintf("%s (%s); ", path, fields[path])
+	}
+	return description
+}
+
+func typeOf(value interface{}, location *time.Location) FieldType {
+	switch v := value.(type) {
+	case float64:
+		return FieldTypeNumber
+	case bool:
+		return FieldTypeBool
+	case []interface{}:
+		return FieldTypeList
+	case string:
+		if _, ok := daterange.ParseTime(v, location); ok {
+			return FieldTypeTime
+		}
+	}
+	return FieldTypeString
+}
@@ -0,0 +1,44 @@
+package querydsl
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testHistory = `[
+	{"eventId": "1", "eventTime": "2024-03-04T08:00:00Z", "event": {"place": "Gym", "minutes": 45, "tags": ["sport", "morning"]}},
+	{"eventId": "2", "eventTime": "2024-03-20T18:30:00Z", "event": {"place": "gym downtown", "minutes": 30, "tags": ["sport"]}},
+	{"eventId": "3", "eventTime": "2024-04-02T12:00:00Z", "event": {"place": "Cafe", "minutes": 60, "cost": "cheap"}},
+	{"eventId": "4", "eventTime": "2024-04-15T09:00:00Z", "event": {"place": "Gym", "cost": 12}}
+]`
+
+func testEvents(t *testing.T) []Event {
+	t.Helper()
+	events, err := ParseEvents(testHistory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestFields(t *testing.T) {
+	want := map[string]FieldType{
+		"eventId":       FieldTypeString,
+		"eventTime":     FieldTypeTime,
+		"event.place":   FieldTypeString,
+		"event.minutes": FieldTypeNumber,
+		"event.tags":    FieldTypeList,
+		// a number in one event and a string in another
+		"event.cost": FieldTypeString,
+	}
+	if fields := Fields(testEvents(t), time.UTC); !reflect.DeepEqual(fields, want) {
+		t.Errorf("Fields = %v, want %v", fields, want)
+	}
+}
+
+func TestParseEventsRejectsNonArray(t *testing.T) {
+	if _, err := ParseEvents(`{"eventId": "1"}`); err == nil {
+		t.Error("ParseEvents of an object succeeded")
+	}
+}
@@ -0,0 +1,282 @@
+package querydsl
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/timemachine-app/timemachine-be/daterange"
+)
+
+// GroupResult holds the aggregates of one group and the events behind them.
+// An aggregate without any value to work on is nil.
+type GroupResult struct {
+	Group    map[string]string      `json:"group"`
+	Values   map[string]interface{} `json:"values"`
+	EventIds []string               `json:"eventIds"`
+}
+
+// Execute runs a validated query over the events. Times are read, compared
+// and grouped in location. A query without grouping always has one result,
+// counting zero when no event matches.
+func Execute(q Query, events []Event, location *time.Location) []GroupResult {
+	groups := map[string]*groupState{}
+	var keys []string
+
+	for _, event := range events {
+		if !matchesAll(event, q.Filters, location) {
+			continue
+		}
+
+		groupValues := map[string]string{}
+		keyParts := make([]string, len(q.GroupBy))
+		for i, groupBy := range q.GroupBy {
+			groupValues[groupBy] = groupValue(event, groupBy, location)
+			keyParts[i] = groupValues[groupBy]
+		}
+		key := strings.Join(keyParts, "\x00")
+
+		group, ok := groups[key]
+		if !ok {
+			group = &groupState{values: groupValues}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		group.events = append(group.events, event)
+	}
+
+	if len(q.GroupBy) == 0 && len(keys) == 0 {
+		groups[""] = &groupState{values: map[string]string{}}
+		keys = append(keys, "")
+	}
+
+	sort.Strings(keys)
+	results := []GroupResult{}
+	for _, key := range keys {
+		group := groups[key]
+		result := GroupResult{
+			Group:    group.values,
+			Values:   map[string]interface{}{},
+			EventIds: []string{},
+		}
+		for _, event := range group.events {
+			if event.Id != "" {
+				result.EventIds = append(result.EventIds, event.Id)
+			}
+		}
+		for _, aggregate := range q.Aggregates {
+			result.Values[aggregate.Name()] = aggregateValue(aggregate, group.events)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+type groupState struct {
+	values map[string]string
+	events []Event
+}
+
+func groupValue(event Event, groupBy string, location *time.Location) string {
+	field, granularity := splitGroupBy(groupBy)
+	value, ok := event.Fields[field]
+	if !ok {
+		return ""
+	}
+
+	if granularity != "" {
+		text, _ := value.(string)
+		t, ok := daterange.ParseTime(text, location)
+		if !ok {
+			return ""
+		}
+		t = t.In(location)
+		switch granularity {
+		case "day":
+			return t.Format("2006-01-02")
+		case "week":
+			offset := (int(t.Weekday()) + 6) % 7
+			return t.AddDate(0, 0, -offset).Format("2006-01-02")
+		case "month":
+			return t.Format("2006-01")
+		default:
+			return t.Format("2006")
+		}
+	}
+
+	if list, ok := value.([]interface{}); ok {
+		parts := make([]string, len(list))
+		for i, item := range list {
+			parts[i] = stringValue(item)
+		}
+		return strings.Join(parts, ", ")
+	}
+	return stringValue(value)
+}
+
+func aggregateValue(aggregate Aggregate, events []Event) interface{} {
+	if aggregate.Func == "count" {
+		return float64(len(events))
+	}
+
+	var numbers []float64
+	for _, event := range events {
+		if number, ok := event.Fields[aggregate.Field].(float64); ok {
+			numbers = append(numbers, number)
+		}
+	}
+	if len(numbers) == 0 {
+		return nil
+	}
+
+	result := numbers[0]
+	switch aggregate.Func {
+	case "sum", "avg":
+		result = 0
+		for _, number := range numbers {
+			result += number
+		}
+		if aggregate.Func == "avg" {
+			result = result / float64(len(numbers))
+		}
+	case "min":
+		for _, number := range numbers {
+			result = math.Min(result, number)
+		}
+	case "max":
+		for _, number := range numbers {
+			result = math.Max(result, number)
+		}
+	}
+	return result
+}
+
+func matchesAll(event Event, filters []Filter, location *time.Location) bool {
+	for _, filter := range filters {
+		if !matches(event, filter, location) {
+			return false
+		}
+	}
+	return true
+}
+
+func matches(event Event, filter Filter, location *time.Location) bool {
+	value, ok := event.Fields[filter.Field]
+	if filter.Op == "exists" {
+		return ok
+	}
+	if !ok {
+		return false
+	}
+
+	// list fields match when any of their items does, or for neq when none
+	// of them is equal
+	if list, isList := value.([]interface{}); isList {
+		if filter.Op == "neq" {
+			for _, item := range list {
+				if equal(item, filter.Value, location) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, item := range list {
+			if matchesValue(item, filter, location) {
+				return true
+			}
+		}
+		return false
+	}
+	return matchesValue(value, filter, location)
+}
+
+func matchesValue(value interface{}, filter Filter, location *time.Location) bool {
+	switch filter.Op {
+	case "eq":
+		return equal(value, filter.Value, location)
+	case "neq":
+		return !equal(value, filter.Value, location)
+	case "contains":
+		return strings.Contains(strings.ToLower(stringValue(value)), strings.ToLower(stringValue(filter.Value)))
+	case "in":
+		candidates, _ := filter.Value.([]interface{})
+		for _, candidate := range candidates {
+			if equal(value, candidate, location) {
+				return true
+			}
+		}
+		return false
+	case "gt", "gte", "lt", "lte":
+		cmp, ok := compare(value, filter.Value, location)
+		if !ok {
+			return false
+		}
+		switch filter.Op {
+		case "gt":
+			return cmp > 0
+		case "gte":
+			return cmp >= 0
+		case "lt":
+			return cmp < 0
+		default:
+			return cmp <= 0
+		}
+	}
+	return false
+}
+
+func equal(value interface{}, expected interface{}, location *time.Location) bool {
+	if cmp, ok := compare(value, expected, location); ok {
+		return cmp == 0
+	}
+	return strings.EqualFold(stringValue(value), stringValue(expected))
+}
+
+// compare orders numbers and timestamps, reporting false for anything else
+func compare(value interface{}, expected interface{}, location *time.Location) (int, bool) {
+	if a, ok := value.(float64); ok {
+		b, ok := expected.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	valueText, ok := value.(string)
+	if !ok {
+		return 0, false
+	}
+	expectedText, ok := expected.(string)
+	if !ok {
+		return 0, false
+	}
+	a, ok := daterange.ParseTime(valueText, location)
+	if !ok {
+		return 0, false
+	}
+	b, ok := daterange.ParseTime(expectedText, location)
+	if !ok {
+		return 0, false
+	}
+	return a.Compare(b), true
+}
+
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%g", v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
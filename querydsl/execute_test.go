@@ -0,0 +1,112 @@
+package querydsl
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExecute(t *testing.T) {
+	events := testEvents(t)
+	count := []Aggregate{{Func: "count"}}
+	noGroup := map[string]string{}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []GroupResult
+	}{
+		{"count", Query{Aggregates: count}, []GroupResult{
+			{Group: noGroup, Values: map[string]interface{}{"count": 4.0}, EventIds: []string{"1", "2", "3", "4"}},
+		}},
+		{"number aggregates skip events without the field", Query{Aggregates: []Aggregate{
+			{Func: "sum", Field: "event.minutes"}, {Func: "avg", Field: "event.minutes"},
+			{Func: "min", Field: "event.minutes"}, {Func: "max", Field: "event.minutes"},
+		}}, []GroupResult{
+			{Group: noGroup, Values: map[string]interface{}{
+				"sum(event.minutes)": 135.0, "avg(event.minutes)": 45.0,
+				"min(event.minutes)": 30.0, "max(event.minutes)": 60.0,
+			}, EventIds: []string{"1", "2", "3", "4"}},
+		}},
+		{"group by month", Query{GroupBy: []string{"eventTime:month"}, Aggregates: count}, []GroupResult{
+			{Group: map[string]string{"eventTime:month": "2024-03"}, Values: map[string]interface{}{"count": 2.0}, EventIds: []string{"1", "2"}},
+			{Group: map[string]string{"eventTime:month": "2024-04"}, Values: map[string]interface{}{"count": 2.0}, EventIds: []string{"3", "4"}},
+		}},
+		{"group by field", Query{GroupBy: []string{"event.place"}, Aggregates: count}, []GroupResult{
+			{Group: map[string]string{"event.place": "Cafe"}, Values: map[string]interface{}{"count": 1.0}, EventIds: []string{"3"}},
+			{Group: map[string]string{"event.place": "Gym"}, Values: map[string]interface{}{"count": 2.0}, EventIds: []string{"1", "4"}},
+			{Group: map[string]string{"event.place": "gym downtown"}, Values: map[string]interface{}{"count": 1.0}, EventIds: []string{"2"}},
+		}},
+		{"eq ignores case", Query{
+			Filters:    []Filter{{Field: "event.place", Op: "eq", Value: "gym"}},
+			Aggregates: count,
+		}, []GroupResult{
+			{Group: noGroup, Values: map[string]interface{}{"count": 2.0}, EventIds: []string{"1", "4"}},
+		}},
+		{"contains", Query{
+			Filters:    []Filter{{Field: "event.place", Op: "contains", Value: "GYM"}},
+			Aggregates: count,
+		}, []GroupResult{
+			{Group: noGroup, Values: map[string]interface{}{"count": 3.0}, EventIds: []string{"1", "2", "4"}},
+		}},
+		{"in", Query{
+			Filters:    []Filter{{Field: "event.place", Op: "in", Value: []interface{}{"Cafe", "Gym"}}},
+			Aggregates: count,
+		}, []GroupResult{
+			{Group: noGroup, Values: map[string]interface{}{"count": 3.0}, EventIds: []string{"1", "3", "4"}},
+		}},
+		{"number bound", Query{
+			Filters:    []Filter{{Field: "event.minutes", Op: "gt", Value: 40.0}},
+			Aggregates: count,
+		}, []GroupResult{
+			{Group: noGroup, Values: map[string]interface{}{"count": 2.0}, EventIds: []string{"1", "3"}},
+		}},
+		{"date bounds", Query{
+			Filters: []Filter{
+				{Field: "eventTime", Op: "gte", Value: "2024-03-10"},
+				{Field: "eventTime", Op: "lt", Value: "2024-04-10"},
+			},
+			Aggregates: count,
+		}, []GroupResult{
+			{Group: noGroup, Values: map[string]interface{}{"count": 2.0}, EventIds: []string{"2", "3"}},
+		}},
+		{"exists", Query{
+			Filters:    []Filter{{Field: "event.cost", Op: "exists"}},
+			Aggregates: count,
+		}, []GroupResult{
+			{Group: noGroup, Values: map[string]interface{}{"count": 2.0}, EventIds: []string{"3", "4"}},
+		}},
+		{"list eq matches any item", Query{
+			Filters:    []Filter{{Field: "event.tags", Op: "eq", Value: "morning"}},
+			Aggregates: count,
+		}, []GroupResult{
+			{Group: noGroup, Values: map[string]interface{}{"count": 1.0}, EventIds: []string{"1"}},
+		}},
+		{"list neq matches when no item is equal", Query{
+			Filters:    []Filter{{Field: "event.tags", Op: "neq", Value: "morning"}},
+			Aggregates: count,
+		}, []GroupResult{
+			{Group: noGroup, Values: map[string]interface{}{"count": 1.0}, EventIds: []string{"2"}},
+		}},
+		{"no match still has a result", Query{
+			Filters:    []Filter{{Field: "event.place", Op: "eq", Value: "library"}},
+			Aggregates: []Aggregate{{Func: "count"}, {Func: "sum", Field: "event.minutes"}},
+		}, []GroupResult{
+			{Group: noGroup, Values: map[string]interface{}{"count": 0.0, "sum(event.minutes)": nil}, EventIds: []string{}},
+		}},
+		{"no match grouped has no results", Query{
+			Filters:    []Filter{{Field: "event.place", Op: "eq", Value: "library"}},
+			GroupBy:    []string{"event.place"},
+			Aggregates: count,
+		}, []GroupResult{}},
+	}
+	for _, test := range tests {
+		if err := test.query.Validate(Fields(events, time.UTC)); err != nil {
+			t.Errorf("%s: Validate: %v", test.name, err)
+			continue
+		}
+		if results := Execute(test.query, events, time.UTC); !reflect.DeepEqual(results, test.want) {
+			t.Errorf("%s: Execute = %+v, want %+v", test.name, results, test.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package querydsl
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	maxFilters    = 20
+	maxGroupBy    = 3
+	maxAggregates = 10
+)
+
+// Query filters events, groups them and computes aggregates per group. It is
+// produced by the LLM from the user's question and executed in Go, so numbers
+// are exact.
+type Query struct {
+	Filters    []Filter    `json:"filters"`
+	GroupBy    []string    `json:"groupBy"`
+	Aggregates []Aggregate `json:"aggregates"`
+}
+
+// Filter compares an event field with a value. Supported operators are eq,
+// neq, contains, in, gt, gte, lt, lte and exists.
+type Filter struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// Aggregate is one of count, sum, avg, min or max over a number field. count
+// takes no field.
+type Aggregate struct {
+	Func  string `json:"func"`
+	Field string `json:"field,omitempty"`
+}
+
+// Name is the key of the aggregate in a group's values
+func (a Aggregate) Name() string {
+	if a.Field == "" {
+		return a.Func
+	}
+	return fmt.Sprintf("%s(%s)", a.Func, a.Field)
+}
+
+var timeGranularities = map[string]bool{
+	"day":   true,
+	"week":  true,
+	"month": true,
+	"year":  true,
+}
+
+// splitGroupBy splits "eventTime:month" into field and time granularity
+func splitGroupBy(groupBy string) (string, string) {
+	field, granularity, _ := strings.Cut(groupBy, ":")
+	return field, granularity
+}
+
+// ValidationError reports a query that can't run over the events
+type ValidationError struct {
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Reason
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &ValidationError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Validate checks the query against the fields known to the events, which
+// may be more than the events being aggregated have. It returns a
+// *ValidationError for a query that doesn't fit them.
+func (q Query) Validate(fields map[string]FieldType) error {
+	if len(q.Filters) > maxFilters || len(q.GroupBy) > maxGroupBy || len(q.Aggregates) > maxAggregates {
+		return invalid("query too large")
+	}
+	if len(q.Aggregates) == 0 {
+		return invalid("query has no aggregates")
+	}
+
+	for _, filter := range q.Filters {
+		fieldType, ok := fields[filter.Field]
+		if !ok {
+			return invalid("unknown field %q", filter.Field)
+		}
+		if err := validateOp(filter, fieldType); err != nil {
+			return err
+		}
+	}
+
+	for _, groupBy := range q.GroupBy {
+		field, granularity := splitGroupBy(groupBy)
+		fieldType, ok := fields[field]
+		if !ok {
+			return invalid("unknown field %q", field)
+		}
+		if granularity != "" && (fieldType != FieldTypeTime || !timeGranularities[granularity]) {
+			return invalid("invalid grouping %q", groupBy)
+		}
+	}
+
+	for _, aggregate := range q.Aggregates {
+		switch aggregate.Func {
+		case "count":
+			if aggregate.Field != "" {
+				return invalid("count takes no field")
+			}
+		case "sum", "avg", "min", "max":
+			fieldType, ok := fields[aggregate.Field]
+			if !ok {
+				return invalid("unknown field %q", aggregate.Field)
+			}
+			// fields with values of mixed types are strings
+			if fieldType != FieldTypeNumber {
+				return invalid("%s needs a number field, %q is a %s field", aggregate.Func, aggregate.Field, fieldType)
+			}
+		default:
+			return invalid("unknown aggregate %q", aggregate.Func)
+		}
+	}
+
+	return nil
+}
+
+func validateOp(filter Filter, fieldType FieldType) error {
+	switch filter.Op {
+	case "exists":
+		return nil
+	case "eq", "neq", "contains":
+		if filter.Value == nil {
+			return invalid("%s on %q needs a value", filter.Op, filter.Field)
+		}
+		return nil
+	case "in":
+		if _, ok := filter.Value.([]interface{}); !ok {
+			return invalid("in on %q needs a list", filter.Field)
+		}
+		return nil
+	case "gt", "gte", "lt", "lte":
+		if fieldType != FieldTypeNumber && fieldType != FieldTypeTime {
+			return invalid("%s needs a number or time field, got %q", filter.Op, filter.Field)
+		}
+		return nil
+	default:
+		return invalid("unknown operator %q", filter.Op)
+	}
+}
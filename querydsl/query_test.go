@@ -0,0 +1,68 @@
+package querydsl
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	fields := Fields(testEvents(t), time.UTC)
+	count := []Aggregate{{Func: "count"}}
+	tooManyFilters := make([]Filter, maxFilters+1)
+	for i := range tooManyFilters {
+		tooManyFilters[i] = Filter{Field: "event.place", Op: "exists"}
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		valid bool
+	}{
+		{"count", Query{Aggregates: count}, true},
+		{"number aggregates", Query{Aggregates: []Aggregate{
+			{Func: "sum", Field: "event.minutes"}, {Func: "avg", Field: "event.minutes"},
+			{Func: "min", Field: "event.minutes"}, {Func: "max", Field: "event.minutes"},
+		}}, true},
+		{"group by week", Query{GroupBy: []string{"eventTime:week"}, Aggregates: count}, true},
+		{"group by field", Query{GroupBy: []string{"event.place"}, Aggregates: count}, true},
+		{"date bounds", Query{Filters: []Filter{
+			{Field: "eventTime", Op: "gte", Value: "2024-03-01"},
+			{Field: "eventTime", Op: "lt", Value: "2024-04-01"},
+		}, Aggregates: count}, true},
+		{"filters", Query{Filters: []Filter{
+			{Field: "event.place", Op: "contains", Value: "gym"},
+			{Field: "event.tags", Op: "in", Value: []interface{}{"sport"}},
+			{Field: "event.cost", Op: "exists"},
+		}, Aggregates: count}, true},
+
+		{"no aggregates", Query{}, false},
+		{"too many filters", Query{Filters: tooManyFilters, Aggregates: count}, false},
+		{"unknown filter field", Query{Filters: []Filter{{Field: "event.mood", Op: "exists"}}, Aggregates: count}, false},
+		{"unknown operator", Query{Filters: []Filter{{Field: "event.place", Op: "like", Value: "gym"}}, Aggregates: count}, false},
+		{"eq without value", Query{Filters: []Filter{{Field: "event.place", Op: "eq"}}, Aggregates: count}, false},
+		{"in without list", Query{Filters: []Filter{{Field: "event.place", Op: "in", Value: "gym"}}, Aggregates: count}, false},
+		{"gt on string", Query{Filters: []Filter{{Field: "event.place", Op: "gt", Value: "a"}}, Aggregates: count}, false},
+		{"unknown group field", Query{GroupBy: []string{"event.mood"}, Aggregates: count}, false},
+		{"granularity of non-time", Query{GroupBy: []string{"event.place:month"}, Aggregates: count}, false},
+		{"unknown granularity", Query{GroupBy: []string{"eventTime:hour"}, Aggregates: count}, false},
+		{"count with field", Query{Aggregates: []Aggregate{{Func: "count", Field: "event.minutes"}}}, false},
+		{"sum of unknown field", Query{Aggregates: []Aggregate{{Func: "sum", Field: "event.mood"}}}, false},
+		{"sum of string", Query{Aggregates: []Aggregate{{Func: "sum", Field: "event.place"}}}, false},
+		{"avg of mixed types", Query{Aggregates: []Aggregate{{Func: "avg", Field: "event.cost"}}}, false},
+		{"unknown aggregate", Query{Aggregates: []Aggregate{{Func: "median", Field: "event.minutes"}}}, false},
+	}
+	for _, test := range tests {
+		err := test.query.Validate(fields)
+		if test.valid {
+			if err != nil {
+				t.Errorf("%s: Validate = %v, want valid", test.name, err)
+			}
+			continue
+		}
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: Validate = %v, want a ValidationError", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	// keys are spread over shards so requests of different clients rarely
+	// wait for the same lock
+	shardCount = 64
+	// how often a shard drops its idle keys
+	sweepInterval = time.Minute
+)
+
+type shard struct {
+	mu sync.Mutex
+	// theoretical arrival time by key
+	tats    map[string]time.Time
+	sweptAt time.Time
+}
+
+// MemoryStore limits requests in process memory, every instance enforces its
+// own limit. Memory only grows with the keys active within their window.
+type MemoryStore struct {
+	shards [shardCount]shard
+	now    func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{now: time.Now}
+	for i := range m.shards {
+		m.shards[i].tats = make(map[string]time.Time)
+	}
+	return m
+}
+
+func (m *MemoryStore) Allow(key string, limit int, window time.Duration, cost int) (Result, error) {
+	if limit <= 0 {
+		return rejectAll(limit, window), nil
+	}
+
+	now := m.now()
+	s := m.shard(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.sweptAt) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	tat, ok := s.tats[key]
+	if !ok || tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(emissionInterval(limit, window) * time.Duration(cost))
+	allowAt := newTat.Add(-window)
+	if allowAt.After(now) {
+		return newResult(false, limit, window, tat.Sub(now), allowAt.Sub(now)), nil
+	}
+
+	s.tats[key] = newTat
+	return newResult(true, limit, window, newTat.Sub(now), 0), nil
+}
+
+func (m *MemoryStore) shard(key string) *shard {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return &m.shards[hash.Sum32()%shardCount]
+}
+
+// sweep drops the keys whose limit is fully available again, they behave the
+// same as keys never seen
+func (s *shard) sweep(now time.Time) {
+	for key, tat := range s.tats {
+		if !tat.After(now) {
+			delete(s.tats, key)
+		}
+	}
+	s.sweptAt = now
+}
@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestMemoryStore returns a store whose clock is moved by advancing the
+// returned time
+func newTestMemoryStore() (*MemoryStore, *time.Time) {
+	now := t0
+	m := NewMemoryStore()
+	m.now = func() time.Time { return now }
+	return m, &now
+}
+
+// wantResult fails the test when the result differs from the expected one
+func wantResult(t *testing.T, name string, result Result, err error, want Result) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: Allow: %v", name, err)
+	}
+	if result != want {
+		t.Errorf("%s: Allow = %+v, want %+v", name, result, want)
+	}
+}
+
+func TestMemoryStoreAllowsUpToTheLimit(t *testing.T) {
+	m, now := newTestMemoryStore()
+	window := 3 * time.Second
+
+	for i := 1; i <= 3; i++ {
+		result, err := m.Allow("client", 3, window, 1)
+		wantResult(t, fmt.Sprintf("request %d", i), result, err,
+			Result{Allowed: true, Limit: 3, Remaining: 3 - i, ResetAfter: time.Duration(i) * time.Second})
+	}
+
+	result, err := m.Allow("client", 3, window, 1)
+	wantResult(t, "over the limit", result, err,
+		Result{Allowed: false, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second, RetryAfter: time.Second})
+
+	// one request is freed every emission interval
+	*now = now.Add(time.Second)
+	result, err = m.Allow("client", 3, window, 1)
+	wantResult(t, "after an interval", result, err,
+		Result{Allowed: true, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second})
+
+	// other keys have their own limit
+	result, err = m.Allow("other", 3, window, 1)
+	wantResult(t, "other key", result, err,
+		Result{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: time.Second})
+}
+
+func TestMemoryStoreCost(t *testing.T) {
+	m, _ := newTestMemoryStore()
+	window := 5 * time.Second
+
+	result, err := m.Allow("client", 5, window, 3)
+	wantResult(t, "first", result, err,
+		Result{Allowed: true, Limit: 5, Remaining: 2, ResetAfter: 3 * time.Second})
+
+	// rejected requests aren't taken, the remaining two still fit
+	result, err = m.Allow("client", 5, window, 3)
+	wantResult(t, "too costly", result, err,
+		Result{Allowed: false, Limit: 5, Remaining: 2, ResetAfter: 3 * time.Second, RetryAfter: time.Second})
+	result, err = m.Allow("client", 5, window, 2)
+	wantResult(t, "rest", result, err,
+		Result{Allowed: true, Limit: 5, Remaining: 0, ResetAfter: 5 * time.Second})
+
+	// more than the whole limit never fits
+	result, err = m.Allow("fresh", 5, window, 6)
+	if err != nil || result.Allowed {
+		t.Errorf("cost over the limit: Allow = %+v, %v, want rejected", result, err)
+	}
+}
+
+func TestMemoryStoreIdleKeyIsReset(t *testing.T) {
+	m, now := newTestMemoryStore()
+	window := time.Minute
+
+	for i := 0; i < 2; i++ {
+		m.Allow("client", 2, window, 1)
+	}
+	*now = now.Add(10 * window)
+	result, err := m.Allow("client", 2, window, 1)
+	wantResult(t, "after idling", result, err,
+		Result{Allowed: true, Limit: 2, Remaining: 1, ResetAfter: 30 * time.Second})
+}
+
+func TestMemoryStoreZeroLimit(t *testing.T) {
+	m, _ := newTestMemoryStore()
+	for _, limit := range []int{0, -1} {
+		result, err := m.Allow("client", limit, time.Minute, 1)
+		wantResult(t, fmt.Sprintf("limit %d", limit), result, err,
+			Result{Allowed: false, Limit: limit, RetryAfter: time.Minute})
+	}
+}
+
+func TestMemoryStoreSweepsIdleKeys(t *testing.T) {
+	m, now := newTestMemoryStore()
+	window := time.Second
+
+	// a second key kept in the same shard
+	s := m.shard("idle")
+	active := ""
+	for i := 0; active == ""; i++ {
+		if key := fmt.Sprintf("active-%d", i); m.shard(key) == s {
+			active = key
+		}
+	}
+
+	m.Allow("idle", 1, window, 1)
+	if _, ok := s.tats["idle"]; !ok {
+		t.Fatal("key not kept")
+	}
+
+	// idle, but the shard was swept less than a sweep interval ago
+	*now = now.Add(sweepInterval / 2)
+	m.Allow(active, 1, window, 1)
+	if _, ok := s.tats["idle"]; !ok {
+		t.Fatal("idle key swept before the sweep interval")
+	}
+
+	*now = now.Add(sweepInterval / 2)
+	m.Allow(active, 1, window, 1)
+	if _, ok := s.tats["idle"]; ok {
+		t.Error("idle key not swept")
+	}
+	if _, ok := s.tats[active]; !ok {
+		t.Error("active key swept")
+	}
+}
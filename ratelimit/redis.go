@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "ratelimit:"
+
+// allowScript keeps the theoretical arrival time of a key in microseconds.
+// Reading and updating it run as one script so concurrent requests on other
+// instances can't both take the last request. Times come from the Redis
+// clock, instance clocks don't have to agree. The key expires once it's idle.
+var allowScript = redis.NewScript(`
+local key = KEYS[1]
+local emission = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call('GET', key)) or now
+if tat < now then
+	tat = now
+end
+
+local newTat = tat + cost * emission
+local allowAt = newTat - window
+if allowAt > now then
+	return {0, tat - now, allowAt - now}
+end
+
+redis.call('SET', key, string.format('%d', newTat), 'PX', math.ceil((newTat - now) / 1000))
+return {1, newTat - now, 0}
+`)
+
+// RedisStore limits requests in Redis so the limit holds across instances
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+func (r *RedisStore) Allow(key string, limit int, window time.Duration, cost int) (Result, error) {
+	if limit <= 0 {
+		return rejectAll(limit, window), nil
+	}
+
+	values, err := allowScript.Run(context.Background(), r.client, []string{redisKeyPrefix + key},
+		emissionInterval(limit, window).Microseconds(), window.Microseconds(), cost).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	if len(values) != 3 {
+		return Result{}, fmt.Errorf("unexpected rate limit result: %v", values)
+	}
+
+	resetAfter := time.Duration(values[1]) * time.Microsecond
+	retryAfter := time.Duration(values[2]) * time.Microsecond
+	return newResult(values[0] == 1, limit, window, resetAfter, retryAfter), nil
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	server.SetTime(t0)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisStore(client), server
+}
+
+func TestRedisStoreAllowsUpToTheLimit(t *testing.T) {
+	r, server := newTestRedisStore(t)
+	window := 3 * time.Second
+
+	for i := 1; i <= 3; i++ {
+		result, err := r.Allow("client", 3, window, 1)
+		wantResult(t, fmt.Sprintf("request %d", i), result, err,
+			Result{Allowed: true, Limit: 3, Remaining: 3 - i, ResetAfter: time.Duration(i) * time.Second})
+	}
+
+	result, err := r.Allow("client", 3, window, 1)
+	wantResult(t, "over the limit", result, err,
+		Result{Allowed: false, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second, RetryAfter: time.Second})
+
+	// one request is freed every emission interval, on the Redis clock
+	server.SetTime(t0.Add(time.Second))
+	result, err = r.Allow("client", 3, window, 1)
+	wantResult(t, "after an interval", result, err,
+		Result{Allowed: true, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second})
+
+	result, err = r.Allow("other", 3, window, 1)
+	wantResult(t, "other key", result, err,
+		Result{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: time.Second})
+}
+
+func TestRedisStoreCost(t *testing.T) {
+	r, _ := newTestRedisStore(t)
+	window := 5 * time.Second
+
+	result, err := r.Allow("client", 5, window, 3)
+	wantResult(t, "first", result, err,
+		Result{Allowed: true, Limit: 5, Remaining: 2, ResetAfter: 3 * time.Second})
+
+	// rejected requests aren't taken, the remaining two still fit
+	result, err = r.Allow("client", 5, window, 3)
+	wantResult(t, "too costly", result, err,
+		Result{Allowed: false, Limit: 5, Remaining: 2, ResetAfter: 3 * time.Second, RetryAfter: time.Second})
+	result, err = r.Allow("client", 5, window, 2)
+	wantResult(t, "rest", result, err,
+		Result{Allowed: true, Limit: 5, Remaining: 0, ResetAfter: 5 * time.Second})
+
+	result, err = r.Allow("fresh", 5, window, 6)
+	if err != nil || result.Allowed {
+		t.Errorf("cost over the limit: Allow = %+v, %v, want rejected", result, err)
+	}
+}
+
+func TestRedisStoreKeyExpires(t *testing.T) {
+	r, server := newTestRedisStore(t)
+	window := time.Minute
+	key := redisKeyPrefix + "client"
+
+	r.Allow("client", 2, window, 1)
+	if ttl := server.TTL(key); ttl != 30*time.Second {
+		t.Errorf("TTL = %v, want 30s until the limit is available again", ttl)
+	}
+	r.Allow("client", 2, window, 1)
+	if ttl := server.TTL(key); ttl != window {
+		t.Errorf("TTL = %v, want the window", ttl)
+	}
+
+	// a rejected request doesn't extend the key
+	r.Allow("client", 2, window, 1)
+	if ttl := server.TTL(key); ttl != window {
+		t.Errorf("TTL after a rejection = %v, want the window", ttl)
+	}
+
+	server.FastForward(window)
+	if server.Exists(key) {
+		t.Fatal("key didn't expire")
+	}
+	server.SetTime(t0.Add(window))
+	result, err := r.Allow("client", 2, window, 1)
+	wantResult(t, "after expiry", result, err,
+		Result{Allowed: true, Limit: 2, Remaining: 1, ResetAfter: 30 * time.Second})
+}
+
+func TestRedisStoreZeroLimit(t *testing.T) {
+	r, server := newTestRedisStore(t)
+	for _, limit := range []int{0, -1} {
+		result, err := r.Allow("client", limit, time.Minute, 1)
+		wantResult(t, fmt.Sprintf("limit %d", limit), result, err,
+			Result{Allowed: false, Limit: limit, RetryAfter: time.Minute})
+	}
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Errorf("zero limit stored keys %v", keys)
+	}
+}
+
+func TestRedisStoreUnreachable(t *testing.T) {
+	r, server := newTestRedisStore(t)
+	server.Close()
+
+	if _, err := r.Allow("client", 3, time.Minute, 1); err == nil {
+		t.Error("Allow with Redis down succeeded")
+	}
+}
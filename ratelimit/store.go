@@ -0,0 +1,53 @@
+package ratelimit
+
+import "time"
+
+// Store limits the requests of keys with the generic cell rate algorithm.
+// Requests are spread evenly over the window and a key may burst up to the
+// whole limit. Only the time at which a key has used up its limit, its
+// theoretical arrival time, is kept, and once it lies in the past the key is
+// idle and can be forgotten.
+type Store interface {
+	// Allow takes cost requests of the key when they fit in the limit within
+	// the window. Rejected requests aren't taken.
+	Allow(key string, limit int, window time.Duration, cost int) (Result, error)
+}
+
+type Result struct {
+	Allowed bool
+	Limit   int
+	// requests that can be made right away
+	Remaining int
+	// until the whole limit is available again
+	ResetAfter time.Duration
+	// until the rejected requests would be allowed, zero when they were
+	RetryAfter time.Duration
+}
+
+// newResult derives the remaining requests from the time until the limit is
+// available again, every emission interval frees one request
+func newResult(allowed bool, limit int, window time.Duration, resetAfter time.Duration, retryAfter time.Duration) Result {
+	remaining := int((window - resetAfter) / emissionInterval(limit, window))
+	if remaining < 0 {
+		remaining = 0
+	}
+	return Result{
+		Allowed:    allowed,
+		Limit:      limit,
+		Remaining:  remaining,
+		ResetAfter: resetAfter,
+		RetryAfter: retryAfter,
+	}
+}
+
+// rejectAll is the result of a limit of zero, which never lets a request through
+func rejectAll(limit int, window time.Duration) Result {
+	return Result{
+		Limit:      limit,
+		RetryAfter: window,
+	}
+}
+
+func emissionInterval(limit int, window time.Duration) time.Duration {
+	return window / time.Duration(limit)
+}
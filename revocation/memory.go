@@ -0,0 +1,59 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	revokedAt time.Time
+	expiresAt time.Time
+}
+
+// MemoryStore keeps revoked subjects in process memory. Expired entries are
+// swept on writes.
+type MemoryStore struct {
+	mu      sync.Mutex
+	revoked map[string]memoryEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		revoked: make(map[string]memoryEntry),
+	}
+}
+
+func (m *MemoryStore) Revoke(subject string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for storedSubject, entry := range m.revoked {
+		if now.After(entry.expiresAt) {
+			delete(m.revoked, storedSubject)
+		}
+	}
+
+	m.revoked[subject] = memoryEntry{
+		revokedAt: now,
+		expiresAt: now.Add(ttl),
+	}
+	return nil
+}
+
+func (m *MemoryStore) IsRevoked(subject string, issuedAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.revoked[subject]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return false, nil
+	}
+	return revokedBefore(issuedAt, entry.revokedAt), nil
+}
+
+// revokedBefore compares in whole seconds like the iat claim, a token issued
+// in the second of the revocation counts as revoked
+func revokedBefore(issuedAt time.Time, revokedAt time.Time) bool {
+	return issuedAt.Unix() <= revokedAt.Unix()
+}
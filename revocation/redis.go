@@ -0,0 +1,42 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "revoked:"
+
+// RedisStore keeps revoked subjects in Redis so every instance rejects them
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+func (r *RedisStore) Revoke(subject string, ttl time.Duration) error {
+	err := r.client.Set(context.Background(), redisKeyPrefix+subject, time.Now().Unix(), ttl).Err()
+	if err != nil {
+		return fmt.Errorf("failed to revoke subject: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisStore) IsRevoked(subject string, issuedAt time.Time) (bool, error) {
+	revokedAt, err := r.client.Get(context.Background(), redisKeyPrefix+subject).Int64()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check revoked subject: %w", err)
+	}
+	return revokedBefore(issuedAt, time.Unix(revokedAt, 0)), nil
+}
@@ -0,0 +1,91 @@
+package revocation
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/timemachine-app/timemachine-be/superbase"
+)
+
+// SessionSet keeps the ids of revoked sessions in memory, so checking a token
+// doesn't need a lookup. Every instance reloads the set from Supabase, a
+// session revoked on another instance is rejected from the next reload on.
+type SessionSet struct {
+	supabaseClient *superbase.SupabaseClient
+	// sessions revoked longer ago only have expired access tokens left
+	window time.Duration
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+func NewSessionSet(supabaseClient *superbase.SupabaseClient, window time.Duration) *SessionSet {
+	return &SessionSet{
+		supabaseClient: supabaseClient,
+		window:         window,
+		revoked:        make(map[string]time.Time),
+	}
+}
+
+// Load replaces the set with the sessions revoked within the window
+func (s *SessionSet) Load() error {
+	loadedAt := time.Now()
+	sessionIds, err := s.supabaseClient.GetRevokedSessionIds(loadedAt.Add(-s.window))
+	if err != nil {
+		return err
+	}
+
+	revoked := make(map[string]time.Time, len(sessionIds))
+	for _, sessionId := range sessionIds {
+		revoked[sessionId] = loadedAt
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// keep sessions added locally while loading, storage may not show them yet
+	for sessionId, addedAt := range s.revoked {
+		if _, ok := revoked[sessionId]; !ok && !addedAt.Before(loadedAt) {
+			revoked[sessionId] = addedAt
+		}
+	}
+	s.revoked = revoked
+	return nil
+}
+
+// Start reloads the set until ctx is done
+func (s *SessionSet) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Load(); err != nil {
+					log.Printf("failed to reload revoked sessions: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Add rejects the session on this instance right away, the revocation itself
+// has to be stored for other instances to pick it up
+func (s *SessionSet) Add(sessionId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked[sessionId] = time.Now()
+}
+
+func (s *SessionSet) IsRevoked(sessionId string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.revoked[sessionId]
+	return ok
+}
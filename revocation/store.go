@@ -0,0 +1,11 @@
+package revocation
+
+import "time"
+
+// Store is a denylist of token subjects. Access tokens of a subject issued
+// before it was revoked are rejected until the entry expires, which should be
+// no earlier than the tokens themselves. Tokens issued later are accepted.
+type Store interface {
+	Revoke(subject string, ttl time.Duration) error
+	IsRevoked(subject string, issuedAt time.Time) (bool, error)
+}
@@ -0,0 +1,64 @@
+package searchsession
+
+import (
+	"sync"
+	"time"
+)
+
+// expired sessions are swept at most this often
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	session   Session
+	expiresAt time.Time
+}
+
+// MemoryStore keeps sessions in process memory. Expired sessions are swept on
+// writes, at most once per sweep interval.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memoryEntry
+	sweptAt  time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]memoryEntry),
+	}
+}
+
+func (m *MemoryStore) Get(id string) (Session, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.sessions[id]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return Session{}, false, nil
+	}
+	return entry.session, true, nil
+}
+
+func (m *MemoryStore) Save(session Session, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.sweptAt) >= sweepInterval {
+		m.sweep(now)
+	}
+
+	m.sessions[session.Id] = memoryEntry{
+		session:   session,
+		expiresAt: now.Add(ttl),
+	}
+	return nil
+}
+
+func (m *MemoryStore) sweep(now time.Time) {
+	for id, entry := range m.sessions {
+		if now.After(entry.expiresAt) {
+			delete(m.sessions, id)
+		}
+	}
+	m.sweptAt = now
+}
@@ -0,0 +1,52 @@
+package searchsession
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "searchsession:"
+
+// RedisStore keeps sessions in Redis so they are shared between instances
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{
+		client: client,
+	}
+}
+
+func (r *RedisStore) Get(id string) (Session, bool, error) {
+	data, err := r.client.Get(context.Background(), redisKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Session{}, false, nil
+	}
+	if err != nil {
+		return Session{}, false, fmt.Errorf("failed to get search session: %w", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return Session{}, false, fmt.Errorf("failed to decode search session: %w", err)
+	}
+	return session, true, nil
+}
+
+func (r *RedisStore) Save(session Session, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode search session: %w", err)
+	}
+
+	if err := r.client.Set(context.Background(), redisKeyPrefix+session.Id, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save search session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package searchsession
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Turn is one question and answer of a search conversation
+type Turn struct {
+	Query           string          `json:"query"`
+	StandaloneQuery string          `json:"standaloneQuery"`
+	Answer          json.RawMessage `json:"answer"`
+	EventIds        []string        `json:"eventIds"`
+}
+
+// Session holds the recent turns of a search conversation. Owner is the client
+// identifier that started it so sessions can't be picked up by anyone else.
+// Turns quote event content, so stores only ever see them as SealedTurns.
+type Session struct {
+	Id          string `json:"id"`
+	Owner       string `json:"owner"`
+	Turns       []Turn `json:"turns,omitempty"`
+	SealedTurns string `json:"sealedTurns,omitempty"`
+}
+
+// Store keeps sessions for a limited time after their last update
+type Store interface {
+	// Get returns the session, or false when it doesn't exist or has expired
+	Get(id string) (Session, bool, error)
+	Save(session Session, ttl time.Duration) error
+}
+
+func NewSession(owner string) (Session, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return Session{}, err
+	}
+	return Session{
+		Id:    hex.EncodeToString(idBytes),
+		Owner: owner,
+		Turns: []Turn{},
+	}, nil
+}
+
+// AddTurn appends a turn, keeping at most maxTurns of the most recent ones
+func (s *Session) AddTurn(turn Turn, maxTurns int) {
+	s.Turns = append(s.Turns, turn)
+	if maxTurns > 0 && len(s.Turns) > maxTurns {
+		s.Turns = s.Turns[len(s.Turns)-maxTurns:]
+	}
+}
+
+// LastEventIds returns the events retrieved for the previous turn
+func (s *Session) LastEventIds() []string {
+	if len(s.Turns) == 0 {
+		return nil
+	}
+	return s.Turns[len(s.Turns)-1].EventIds
+}
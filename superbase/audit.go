@@ -0,0 +1,36 @@
+package superbase
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	AuditOutcomeSucceeded = "succeeded"
+	AuditOutcomeFailed    = "failed"
+)
+
+// AuditEvent records an admin acting on another user's account and whether
+// the action succeeded
+type AuditEvent struct {
+	ActorUserId  string `json:"ActorUserId"`
+	Action       string `json:"Action"`
+	TargetUserId string `json:"TargetUserId"`
+	Outcome      string `json:"Outcome"`
+	CreatedAt    string `json:"created_at,omitempty"`
+}
+
+func (s *SupabaseClient) AddAuditEvent(event AuditEvent) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.AuditTableName)
+
+	req, err := s.newRequest("POST", requestUrl, event)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusCreated, nil); err != nil {
+		return fmt.Errorf("failed to add audit event: %w", err)
+	}
+
+	return nil
+}
@@ -3,9 +3,12 @@ package superbase
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/timemachine-app/timemachine-be/internal/config"
 )
@@ -14,7 +17,15 @@ type User struct {
 	UserId         string `json:"UserId,omitempty"` // omit empty to exclude from POST requests
 	Email          string `json:"Email"`
 	ExternalUserId string `json:"ExternalUserId"`
-	CreatedAt      string `json:"created_at,omitempty"` // omit empty to exclude from POST requests
+	// encrypted with the user's data key, needed to revoke the sign in with Apple
+	AppleRefreshToken string `json:"AppleRefreshToken,omitempty"`
+	// empty for regular users, "admin" for admins and "guest" for anonymous users
+	Role string `json:"Role,omitempty"`
+	// plan the user is subscribed to, which sets its rate limits, empty for the free plan
+	Plan string `json:"Plan,omitempty"`
+	// set while the account is disabled, e.g. after the user revoked consent
+	DisabledAt *string `json:"DisabledAt,omitempty"`
+	CreatedAt  string  `json:"created_at,omitempty"` // omit empty to exclude from POST requests
 }
 
 type UsageEvent struct {
@@ -22,6 +33,21 @@ type UsageEvent struct {
 	EventType string `json:"EventType"`
 }
 
+type StoredEvent struct {
+	EventId   string `json:"EventId,omitempty"` // omit empty to exclude from POST requests
+	UserId    string `json:"UserId"`
+	EventTime string `json:"EventTime"`
+	Content   string `json:"Content"`   // encrypted with the user's data key
+	Embedding string `json:"Embedding"` // encrypted with the user's data key
+	CreatedAt string `json:"created_at,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+type DataKey struct {
+	UserId     string `json:"UserId"`
+	WrappedKey string `json:"WrappedKey"` // encrypted with the master key
+}
+
 type SupabaseClient struct {
 	superbaseConfig config.SuperbaseConfig
 }
@@ -32,44 +58,53 @@ func NewSupabaseClient(superbaseConfig config.SuperbaseConfig) *SupabaseClient {
 	}
 }
 
-func (s *SupabaseClient) AddUser(user User) error {
-	url := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.AccountTableName)
+// AddUser creates the user and returns it with its generated id
+func (s *SupabaseClient) AddUser(user User) (User, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.AccountTableName)
 
-	jsonData, err := json.Marshal(user)
+	req, err := s.newRequest("POST", requestUrl, user)
 	if err != nil {
-		return fmt.Errorf("failed to marshal user data: %w", err)
+		return User{}, err
 	}
+	req.Header.Set("Prefer", "return=representation")
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	var users []User
+	if err := s.do(req, http.StatusCreated, &users); err != nil {
+		return User{}, fmt.Errorf("failed to add user: %w", err)
+	}
+	if len(users) == 0 {
+		return User{}, fmt.Errorf("failed to add user: empty response")
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("apikey", s.superbaseConfig.Key)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.superbaseConfig.Key))
+	return users[0], nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func (s *SupabaseClient) GetUser(externalUserId string) (User, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?ExternalUserId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.AccountTableName, url.QueryEscape(externalUserId))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
 	if err != nil {
-		return fmt.Errorf("failed to execute request: %w", err)
+		return User{}, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("failed to add user, status code: %d, response")
+	var users []User
+	if err := s.do(req, http.StatusOK, &users); err != nil {
+		return User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	if len(users) == 0 {
+		return User{}, ErrNotFound
 	}
 
-	return nil
+	return users[0], nil
 }
 
-func (s *SupabaseClient) GetUser(externalUserId string) (User, error) {
-	var users []User
-	url := fmt.Sprintf("%s/rest/v1/%s?ExternalUserId=eq.%s", s.superbaseConfig.Url, s.superbaseConfig.AccountTableName, externalUserId)
+func (s *SupabaseClient) DeleteUser(userId string) error {
+	url := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s", s.superbaseConfig.Url, s.superbaseConfig.AccountTableName, userId)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
-		return User{}, fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("apikey", s.superbaseConfig.Key)
@@ -78,36 +113,101 @@ func (s *SupabaseClient) GetUser(externalUserId string) (User, error) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return User{}, fmt.Errorf("failed to execute request: %w", err)
+		return fmt.Errorf("failed to execute request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusNoContent {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		bodyString := string(bodyBytes)
-		return User{}, fmt.Errorf("failed to get user, status code: %d, response: %s", resp.StatusCode, bodyString)
+		return fmt.Errorf("failed to delete user, status code: %d, response: %s", resp.StatusCode, bodyString)
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&users)
+	return nil
+}
+
+func (s *SupabaseClient) GetUserById(userId string) (User, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.AccountTableName, url.QueryEscape(userId))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
 	if err != nil {
-		return User{}, fmt.Errorf("failed to decode response: %w", err)
+		return User{}, err
 	}
 
+	var users []User
+	if err := s.do(req, http.StatusOK, &users); err != nil {
+		return User{}, fmt.Errorf("failed to get user: %w", err)
+	}
 	if len(users) == 0 {
-		return User{}, fmt.Errorf("user not found")
+		return User{}, ErrNotFound
 	}
 
 	return users[0], nil
 }
 
-func (s *SupabaseClient) DeleteUser(userId string) error {
-	url := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s", s.superbaseConfig.Url, s.superbaseConfig.AccountTableName, userId)
+func (s *SupabaseClient) UpdateUserAppleRefreshToken(userId string, appleRefreshToken string) error {
+	return s.updateUser(userId, map[string]interface{}{
+		"AppleRefreshToken": appleRefreshToken,
+	})
+}
 
-	req, err := http.NewRequest("DELETE", url, nil)
+// UpgradeGuestUser turns a guest into a regular user signed in with a provider
+func (s *SupabaseClient) UpgradeGuestUser(userId string, email string, externalUserId string) error {
+	return s.updateUser(userId, map[string]interface{}{
+		"Role":           nil,
+		"Email":          email,
+		"ExternalUserId": externalUserId,
+	})
+}
+
+func (s *SupabaseClient) UpdateUserEmail(userId string, email string) error {
+	return s.updateUser(userId, map[string]interface{}{
+		"Email": email,
+	})
+}
+
+// SetUserDisabled disables the account or enables it again
+func (s *SupabaseClient) SetUserDisabled(userId string, disabled bool) error {
+	var disabledAt interface{}
+	if disabled {
+		disabledAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	return s.updateUser(userId, map[string]interface{}{
+		"DisabledAt": disabledAt,
+	})
+}
+
+func (s *SupabaseClient) updateUser(userId string, fields map[string]interface{}) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.AccountTableName, url.QueryEscape(userId))
+
+	req, err := s.newRequest("PATCH", requestUrl, fields)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SupabaseClient) AddUsageEvent(event UsageEvent) error {
+	url := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.UsageTableName)
+
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage event data: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("apikey", s.superbaseConfig.Key)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.superbaseConfig.Key))
 
@@ -118,32 +218,81 @@ func (s *SupabaseClient) DeleteUser(userId string) error {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent {
+	if resp.StatusCode != http.StatusCreated {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		bodyString := string(bodyBytes)
-		return fmt.Errorf("failed to delete user, status code: %d, response: %s", resp.StatusCode, bodyString)
+		return fmt.Errorf("failed to add usage event, status code: %d, response: %s", resp.StatusCode, bodyString)
 	}
 
 	return nil
 }
 
-func (s *SupabaseClient) AddUsageEvent(event UsageEvent) error {
-	url := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.UsageTableName)
+// ReassignUsageEvents moves the usage history of one user to another
+func (s *SupabaseClient) ReassignUsageEvents(fromUserId string, toUserId string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.UsageTableName, url.QueryEscape(fromUserId))
 
-	jsonData, err := json.Marshal(event)
+	req, err := s.newRequest("PATCH", requestUrl, map[string]string{
+		"UserId": toUserId,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to marshal usage event data: %w", err)
+		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to reassign usage events: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUserUsageEvents removes the usage history of the user
+func (s *SupabaseClient) DeleteUserUsageEvents(userId string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.UsageTableName, url.QueryEscape(userId))
+
+	req, err := s.newRequest("DELETE", requestUrl, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to delete usage events: %w", err)
+	}
+
+	return nil
+}
+
+// newRequest builds an authenticated request against the Supabase REST API
+func (s *SupabaseClient) newRequest(method string, url string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request data: %w", err)
+		}
+		reader = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	req.Header.Set("apikey", s.superbaseConfig.Key)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.superbaseConfig.Key))
 
+	return req, nil
+}
+
+// ErrConflict is returned when a row violates a unique constraint
+var ErrConflict = errors.New("conflict")
+
+// do executes the request and decodes the response into out when it is not nil
+func (s *SupabaseClient) do(req *http.Request, expectedStatus int, out interface{}) error {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -151,10 +300,19 @@ func (s *SupabaseClient) AddUsageEvent(event UsageEvent) error {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
+	if resp.StatusCode != expectedStatus {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		bodyString := string(bodyBytes)
-		return fmt.Errorf("failed to add usage event, status code: %d, response: %s", resp.StatusCode, bodyString)
+		if resp.StatusCode == http.StatusConflict {
+			return fmt.Errorf("%w: %s", ErrConflict, bodyString)
+		}
+		return fmt.Errorf("status code: %d, response: %s", resp.StatusCode, bodyString)
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
 	}
 
 	return nil
@@ -0,0 +1,299 @@
+package superbase
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+func (s *SupabaseClient) AddEvent(event StoredEvent) (StoredEvent, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.EventTableName)
+
+	req, err := s.newRequest("POST", requestUrl, event)
+	if err != nil {
+		return StoredEvent{}, err
+	}
+	req.Header.Set("Prefer", "return=representation")
+
+	var events []StoredEvent
+	if err := s.do(req, http.StatusCreated, &events); err != nil {
+		return StoredEvent{}, fmt.Errorf("failed to add event: %w", err)
+	}
+	if len(events) == 0 {
+		return StoredEvent{}, fmt.Errorf("failed to add event: empty response")
+	}
+
+	return events[0], nil
+}
+
+// GetEvents returns a page of the user's events, newest first
+func (s *SupabaseClient) GetEvents(userId string, limit int, offset int) ([]StoredEvent, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s&order=EventTime.desc,EventId.desc&limit=%d&offset=%d",
+		s.superbaseConfig.Url, s.superbaseConfig.EventTableName, url.QueryEscape(userId), limit, offset)
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []StoredEvent
+	if err := s.do(req, http.StatusOK, &events); err != nil {
+		return nil, fmt.Errorf("failed to get events: %w", err)
+	}
+
+	return events, nil
+}
+
+// GetEventsBetween returns a page of the user's events that happened in
+// [start, end), newest first
+func (s *SupabaseClient) GetEventsBetween(userId string, start time.Time, end time.Time, limit int, offset int) ([]StoredEvent, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s&EventTime=gte.%s&EventTime=lt.%s&order=EventTime.desc,EventId.desc&limit=%d&offset=%d",
+		s.superbaseConfig.Url, s.superbaseConfig.EventTableName, url.QueryEscape(userId),
+		url.QueryEscape(start.Format(time.RFC3339)), url.QueryEscape(end.Format(time.RFC3339)), limit, offset)
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []StoredEvent
+	if err := s.do(req, http.StatusOK, &events); err != nil {
+		return nil, fmt.Errorf("failed to get events: %w", err)
+	}
+
+	return events, nil
+}
+
+func (s *SupabaseClient) GetEvent(userId string, eventId string) (StoredEvent, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s&EventId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.EventTableName, url.QueryEscape(userId), url.QueryEscape(eventId))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return StoredEvent{}, err
+	}
+
+	var events []StoredEvent
+	if err := s.do(req, http.StatusOK, &events); err != nil {
+		return StoredEvent{}, fmt.Errorf("failed to get event: %w", err)
+	}
+	if len(events) == 0 {
+		return StoredEvent{}, ErrNotFound
+	}
+
+	return events[0], nil
+}
+
+func (s *SupabaseClient) UpdateEvent(event StoredEvent) (StoredEvent, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s&EventId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.EventTableName, url.QueryEscape(event.UserId), url.QueryEscape(event.EventId))
+
+	req, err := s.newRequest("PATCH", requestUrl, map[string]string{
+		"EventTime": event.EventTime,
+		"Content":   event.Content,
+		"Embedding": event.Embedding,
+	})
+	if err != nil {
+		return StoredEvent{}, err
+	}
+	req.Header.Set("Prefer", "return=representation")
+
+	var events []StoredEvent
+	if err := s.do(req, http.StatusOK, &events); err != nil {
+		return StoredEvent{}, fmt.Errorf("failed to update event: %w", err)
+	}
+	if len(events) == 0 {
+		return StoredEvent{}, ErrNotFound
+	}
+
+	return events[0], nil
+}
+
+// MoveEvent hands a stored event over to another user in a single update, with
+// its content and embedding encrypted for that user. Moving an event that was
+// already moved returns ErrNotFound.
+func (s *SupabaseClient) MoveEvent(fromUserId string, event StoredEvent) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s&EventId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.EventTableName, url.QueryEscape(fromUserId), url.QueryEscape(event.EventId))
+
+	req, err := s.newRequest("PATCH", requestUrl, map[string]string{
+		"UserId":    event.UserId,
+		"Content":   event.Content,
+		"Embedding": event.Embedding,
+	})
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Prefer", "return=representation")
+
+	var events []StoredEvent
+	if err := s.do(req, http.StatusOK, &events); err != nil {
+		return fmt.Errorf("failed to move event: %w", err)
+	}
+	if len(events) == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// GetEventsByIds returns the user's events with the given ids, in no particular order
+func (s *SupabaseClient) GetEventsByIds(userId string, eventIds []string) ([]StoredEvent, error) {
+	if len(eventIds) == 0 {
+		return []StoredEvent{}, nil
+	}
+
+	escapedIds := make([]string, len(eventIds))
+	for i, eventId := range eventIds {
+		escapedIds[i] = url.QueryEscape(eventId)
+	}
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s&EventId=in.(%s)",
+		s.superbaseConfig.Url, s.superbaseConfig.EventTableName, url.QueryEscape(userId), strings.Join(escapedIds, ","))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []StoredEvent
+	if err := s.do(req, http.StatusOK, &events); err != nil {
+		return nil, fmt.Errorf("failed to get events: %w", err)
+	}
+
+	return events, nil
+}
+
+// GetLatestEventUpdate returns when the user's events were last added or
+// changed, empty when the user has none
+func (s *SupabaseClient) GetLatestEventUpdate(userId string) (string, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s&select=updated_at&order=updated_at.desc&limit=1",
+		s.superbaseConfig.Url, s.superbaseConfig.EventTableName, url.QueryEscape(userId))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var events []StoredEvent
+	if err := s.do(req, http.StatusOK, &events); err != nil {
+		return "", fmt.Errorf("failed to get latest event update: %w", err)
+	}
+	if len(events) == 0 {
+		return "", nil
+	}
+
+	return events[0].UpdatedAt, nil
+}
+
+// UpdateEventEmbedding backfills the embedding of an already stored event
+func (s *SupabaseClient) UpdateEventEmbedding(userId string, eventId string, embedding string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s&EventId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.EventTableName, url.QueryEscape(userId), url.QueryEscape(eventId))
+
+	req, err := s.newRequest("PATCH", requestUrl, map[string]string{
+		"Embedding": embedding,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to update event embedding: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SupabaseClient) DeleteEvent(userId string, eventId string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s&EventId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.EventTableName, url.QueryEscape(userId), url.QueryEscape(eventId))
+
+	req, err := s.newRequest("DELETE", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Prefer", "return=representation")
+
+	var events []StoredEvent
+	if err := s.do(req, http.StatusOK, &events); err != nil {
+		return fmt.Errorf("failed to delete event: %w", err)
+	}
+	if len(events) == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteUserEvents removes every stored event of the user
+func (s *SupabaseClient) DeleteUserEvents(userId string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.EventTableName, url.QueryEscape(userId))
+
+	req, err := s.newRequest("DELETE", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to delete events: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SupabaseClient) AddDataKey(dataKey DataKey) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.DataKeyTableName)
+
+	req, err := s.newRequest("POST", requestUrl, dataKey)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusCreated, nil); err != nil {
+		return fmt.Errorf("failed to add data key: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SupabaseClient) GetDataKey(userId string) (DataKey, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.DataKeyTableName, url.QueryEscape(userId))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return DataKey{}, err
+	}
+
+	var dataKeys []DataKey
+	if err := s.do(req, http.StatusOK, &dataKeys); err != nil {
+		return DataKey{}, fmt.Errorf("failed to get data key: %w", err)
+	}
+	if len(dataKeys) == 0 {
+		return DataKey{}, ErrNotFound
+	}
+
+	return dataKeys[0], nil
+}
+
+func (s *SupabaseClient) DeleteDataKey(userId string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.DataKeyTableName, url.QueryEscape(userId))
+
+	req, err := s.newRequest("DELETE", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to delete data key: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package superbase
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// UserIdentity links the account of a sign in provider to a user. A user can
+// have identities of several providers.
+type UserIdentity struct {
+	Provider  string `json:"Provider"`
+	Subject   string `json:"Subject"`
+	UserId    string `json:"UserId"`
+	Email     string `json:"Email"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+// MagicLink is a pending email sign in. Only the hash of its token is kept.
+type MagicLink struct {
+	TokenHash string  `json:"TokenHash"`
+	Email     string  `json:"Email"`
+	ExpiresAt string  `json:"ExpiresAt"`
+	UsedAt    *string `json:"UsedAt,omitempty"`
+}
+
+func (s *SupabaseClient) AddUserIdentity(identity UserIdentity) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.IdentityTableName)
+
+	req, err := s.newRequest("POST", requestUrl, identity)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusCreated, nil); err != nil {
+		return fmt.Errorf("failed to add user identity: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SupabaseClient) GetUserIdentity(provider string, subject string) (UserIdentity, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?Provider=eq.%s&Subject=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.IdentityTableName, url.QueryEscape(provider), url.QueryEscape(subject))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return UserIdentity{}, err
+	}
+
+	var identities []UserIdentity
+	if err := s.do(req, http.StatusOK, &identities); err != nil {
+		return UserIdentity{}, fmt.Errorf("failed to get user identity: %w", err)
+	}
+	if len(identities) == 0 {
+		return UserIdentity{}, ErrNotFound
+	}
+
+	return identities[0], nil
+}
+
+func (s *SupabaseClient) DeleteUserIdentities(userId string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.IdentityTableName, url.QueryEscape(userId))
+
+	req, err := s.newRequest("DELETE", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to delete user identities: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUserProviderIdentities deletes the user's identities of one provider
+func (s *SupabaseClient) DeleteUserProviderIdentities(userId string, provider string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s&Provider=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.IdentityTableName, url.QueryEscape(userId), url.QueryEscape(provider))
+
+	req, err := s.newRequest("DELETE", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to delete user identities: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SupabaseClient) GetUserByEmail(email string) (User, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?Email=eq.%s&limit=1",
+		s.superbaseConfig.Url, s.superbaseConfig.AccountTableName, url.QueryEscape(email))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return User{}, err
+	}
+
+	var users []User
+	if err := s.do(req, http.StatusOK, &users); err != nil {
+		return User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	if len(users) == 0 {
+		return User{}, ErrNotFound
+	}
+
+	return users[0], nil
+}
+
+func (s *SupabaseClient) AddMagicLink(link MagicLink) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.MagicLinkTableName)
+
+	req, err := s.newRequest("POST", requestUrl, link)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusCreated, nil); err != nil {
+		return fmt.Errorf("failed to add magic link: %w", err)
+	}
+
+	return nil
+}
+
+// UseMagicLink marks the link as used and returns it. Links that don't exist
+// or were already used return ErrNotFound.
+func (s *SupabaseClient) UseMagicLink(tokenHash string) (MagicLink, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?TokenHash=eq.%s&UsedAt=is.null",
+		s.superbaseConfig.Url, s.superbaseConfig.MagicLinkTableName, url.QueryEscape(tokenHash))
+
+	req, err := s.newRequest("PATCH", requestUrl, map[string]string{
+		"UsedAt": time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return MagicLink{}, err
+	}
+	req.Header.Set("Prefer", "return=representation")
+
+	var links []MagicLink
+	if err := s.do(req, http.StatusOK, &links); err != nil {
+		return MagicLink{}, fmt.Errorf("failed to use magic link: %w", err)
+	}
+	if len(links) == 0 {
+		return MagicLink{}, ErrNotFound
+	}
+
+	return links[0], nil
+}
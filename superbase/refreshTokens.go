@@ -0,0 +1,112 @@
+package superbase
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RefreshToken is the stored state of an opaque refresh token. Only the hash
+// of the token is kept. Tokens rotated from one sign in share a family.
+type RefreshToken struct {
+	TokenHash string  `json:"TokenHash"`
+	FamilyId  string  `json:"FamilyId"`
+	UserId    string  `json:"UserId"`
+	ExpiresAt string  `json:"ExpiresAt"`
+	UsedAt    *string `json:"UsedAt,omitempty"`
+	RevokedAt *string `json:"RevokedAt,omitempty"`
+	CreatedAt string  `json:"created_at,omitempty"`
+}
+
+func (s *SupabaseClient) AddRefreshToken(token RefreshToken) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.RefreshTokenTableName)
+
+	req, err := s.newRequest("POST", requestUrl, token)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusCreated, nil); err != nil {
+		return fmt.Errorf("failed to add refresh token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SupabaseClient) GetRefreshToken(tokenHash string) (RefreshToken, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?TokenHash=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.RefreshTokenTableName, url.QueryEscape(tokenHash))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	var tokens []RefreshToken
+	if err := s.do(req, http.StatusOK, &tokens); err != nil {
+		return RefreshToken{}, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if len(tokens) == 0 {
+		return RefreshToken{}, ErrNotFound
+	}
+
+	return tokens[0], nil
+}
+
+// UseRefreshToken marks the token as used. It reports false when the token was
+// already used, so of two concurrent refreshes with one token only one wins.
+func (s *SupabaseClient) UseRefreshToken(tokenHash string) (bool, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?TokenHash=eq.%s&UsedAt=is.null",
+		s.superbaseConfig.Url, s.superbaseConfig.RefreshTokenTableName, url.QueryEscape(tokenHash))
+
+	req, err := s.newRequest("PATCH", requestUrl, map[string]string{
+		"UsedAt": time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Prefer", "return=representation")
+
+	var tokens []RefreshToken
+	if err := s.do(req, http.StatusOK, &tokens); err != nil {
+		return false, fmt.Errorf("failed to use refresh token: %w", err)
+	}
+
+	return len(tokens) > 0, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token rotated from the same sign in
+func (s *SupabaseClient) RevokeRefreshTokenFamily(familyId string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?FamilyId=eq.%s&RevokedAt=is.null",
+		s.superbaseConfig.Url, s.superbaseConfig.RefreshTokenTableName, url.QueryEscape(familyId))
+
+	req, err := s.newRequest("PATCH", requestUrl, map[string]string{
+		"RevokedAt": time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SupabaseClient) DeleteUserRefreshTokens(userId string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.RefreshTokenTableName, url.QueryEscape(userId))
+
+	req, err := s.newRequest("DELETE", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to delete refresh tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package superbase
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Session is a signed in device. It lasts as long as the refresh token family
+// of the sign in, whose id it shares.
+type Session struct {
+	SessionId  string  `json:"SessionId"`
+	UserId     string  `json:"UserId"`
+	DeviceName string  `json:"DeviceName"`
+	Platform   string  `json:"Platform"`
+	AppVersion string  `json:"AppVersion"`
+	LastSeenAt string  `json:"LastSeenAt"`
+	RevokedAt  *string `json:"RevokedAt,omitempty"`
+	CreatedAt  string  `json:"created_at,omitempty"`
+}
+
+func (s *SupabaseClient) AddSession(session Session) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.SessionTableName)
+
+	req, err := s.newRequest("POST", requestUrl, session)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusCreated, nil); err != nil {
+		return fmt.Errorf("failed to add session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SupabaseClient) GetSession(sessionId string) (Session, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?SessionId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.SessionTableName, url.QueryEscape(sessionId))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return Session{}, err
+	}
+
+	var sessions []Session
+	if err := s.do(req, http.StatusOK, &sessions); err != nil {
+		return Session{}, fmt.Errorf("failed to get session: %w", err)
+	}
+	if len(sessions) == 0 {
+		return Session{}, ErrNotFound
+	}
+
+	return sessions[0], nil
+}
+
+// GetUserSessions returns the sessions of the user that weren't revoked, most
+// recently seen first
+func (s *SupabaseClient) GetUserSessions(userId string) ([]Session, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s&RevokedAt=is.null&order=LastSeenAt.desc",
+		s.superbaseConfig.Url, s.superbaseConfig.SessionTableName, url.QueryEscape(userId))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	if err := s.do(req, http.StatusOK, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// GetRevokedSessionIds returns the ids of sessions revoked since the given time
+func (s *SupabaseClient) GetRevokedSessionIds(since time.Time) ([]string, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?select=SessionId&RevokedAt=gte.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.SessionTableName, url.QueryEscape(since.UTC().Format(time.RFC3339)))
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	if err := s.do(req, http.StatusOK, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to get revoked sessions: %w", err)
+	}
+
+	sessionIds := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		sessionIds = append(sessionIds, session.SessionId)
+	}
+	return sessionIds, nil
+}
+
+func (s *SupabaseClient) TouchSession(sessionId string) error {
+	return s.updateSessions("SessionId", sessionId, map[string]string{
+		"LastSeenAt": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (s *SupabaseClient) RevokeSession(sessionId string) error {
+	return s.updateSessions("SessionId", sessionId, map[string]string{
+		"RevokedAt": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (s *SupabaseClient) RevokeUserSessions(userId string) error {
+	return s.updateSessions("UserId", userId, map[string]string{
+		"RevokedAt": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (s *SupabaseClient) DeleteUserSessions(userId string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?UserId=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.SessionTableName, url.QueryEscape(userId))
+
+	req, err := s.newRequest("DELETE", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+
+	return nil
+}
+
+// updateSessions updates the sessions that weren't revoked where column
+// equals value
+func (s *SupabaseClient) updateSessions(column string, value string, fields map[string]string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?%s=eq.%s&RevokedAt=is.null",
+		s.superbaseConfig.Url, s.superbaseConfig.SessionTableName, column, url.QueryEscape(value))
+
+	req, err := s.newRequest("PATCH", requestUrl, fields)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to update sessions: %w", err)
+	}
+
+	return nil
+}
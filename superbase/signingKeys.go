@@ -0,0 +1,72 @@
+package superbase
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// SigningKey is a key pair signing access tokens
+type SigningKey struct {
+	Kid       string `json:"Kid"`
+	Algorithm string `json:"Algorithm"`
+	// PKIX DER, base64
+	PublicKey string `json:"PublicKey"`
+	// PKCS8 DER sealed with the master key
+	PrivateKey string `json:"PrivateKey"`
+	CreatedAt  string `json:"created_at"`
+	// rotation the key was generated for, unique so that only one instance
+	// generates the key of a rotation
+	Slot int64 `json:"Slot"`
+}
+
+// AddSigningKey stores the key, or returns ErrConflict when a key of the same
+// slot was stored first
+func (s *SupabaseClient) AddSigningKey(key SigningKey) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s", s.superbaseConfig.Url, s.superbaseConfig.SigningKeyTableName)
+
+	req, err := s.newRequest("POST", requestUrl, key)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusCreated, nil); err != nil {
+		return fmt.Errorf("failed to add signing key: %w", err)
+	}
+
+	return nil
+}
+
+// GetSigningKeys returns every stored signing key, oldest first
+func (s *SupabaseClient) GetSigningKeys() ([]SigningKey, error) {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?order=created_at.asc",
+		s.superbaseConfig.Url, s.superbaseConfig.SigningKeyTableName)
+
+	req, err := s.newRequest("GET", requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []SigningKey
+	if err := s.do(req, http.StatusOK, &keys); err != nil {
+		return nil, fmt.Errorf("failed to get signing keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (s *SupabaseClient) DeleteSigningKey(kid string) error {
+	requestUrl := fmt.Sprintf("%s/rest/v1/%s?Kid=eq.%s",
+		s.superbaseConfig.Url, s.superbaseConfig.SigningKeyTableName, url.QueryEscape(kid))
+
+	req, err := s.newRequest("DELETE", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(req, http.StatusNoContent, nil); err != nil {
+		return fmt.Errorf("failed to delete signing key: %w", err)
+	}
+
+	return nil
+}
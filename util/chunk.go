@@ -0,0 +1,99 @@
+package util
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// rough average of characters per token for LLM tokenizers
+const charsPerToken = 4
+
+// EstimateTokens approximates the number of LLM tokens in the text
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// ChunkHistory splits the history into chunks of at most maxTokens estimated
+// tokens. A JSON array is split between its elements and stays a valid JSON
+// array per chunk; any other text is split between lines.
+func ChunkHistory(history string, maxTokens int) []string {
+	if maxTokens <= 0 || EstimateTokens(history) <= maxTokens {
+		return []string{history}
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(history), &items); err == nil {
+		return chunkJSONItems(items, maxTokens)
+	}
+	return chunkLines(history, maxTokens)
+}
+
+func chunkJSONItems(items []json.RawMessage, maxTokens int) []string {
+	var chunks []string
+	var current []json.RawMessage
+	currentTokens := 0
+
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		chunk, _ := json.Marshal(current)
+		chunks = append(chunks, string(chunk))
+		current = nil
+		currentTokens = 0
+	}
+
+	for _, item := range items {
+		itemTokens := EstimateTokens(string(item))
+		if currentTokens+itemTokens > maxTokens {
+			flush()
+		}
+		// an oversized single item still goes out on its own
+		current = append(current, item)
+		currentTokens += itemTokens
+	}
+	flush()
+
+	return chunks
+}
+
+func chunkLines(history string, maxTokens int) []string {
+	var chunks []string
+	var current strings.Builder
+	maxChars := maxTokens * charsPerToken
+
+	for _, line := range strings.SplitAfter(history, "\n") {
+		for utf8.RuneCountInString(line) > maxChars {
+			if current.Len() > 0 {
+				chunks = append(chunks, current.String())
+				current.Reset()
+			}
+			head, tail := splitRunes(line, maxChars)
+			chunks = append(chunks, head)
+			line = tail
+		}
+
+		if EstimateTokens(current.String()+line) > maxTokens {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		current.WriteString(line)
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+
+	return chunks
+}
+
+func splitRunes(text string, n int) (string, string) {
+	count := 0
+	for idx := range text {
+		if count == n {
+			return text[:idx], text[idx:]
+		}
+		count++
+	}
+	return text, ""
+}
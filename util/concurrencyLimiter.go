@@ -0,0 +1,179 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timemachine-app/timemachine-be/internal/config"
+)
+
+// ErrSaturated is returned when every slot is taken and the request can't
+// wait for one
+var ErrSaturated = errors.New("too many requests in flight")
+
+// ConcurrencyLimiter caps the provider calls running at once. Calls beyond
+// the cap wait in a bounded queue for at most the queue timeout, calls of
+// background work wait outside the queue as long as their context allows, so
+// they neither take the places of requests nor shed them.
+type ConcurrencyLimiter struct {
+	slots           chan struct{}
+	maxQueue        int64
+	queueTimeout    time.Duration
+	retryAfterInSec int
+
+	queued atomic.Int64
+	// background calls waiting for a slot, not counted in queued
+	waiting  atomic.Int64
+	admitted atomic.Int64
+	rejected atomic.Int64
+	timedOut atomic.Int64
+}
+
+// ConcurrencyStats is the state of a limiter as published on /metrics
+type ConcurrencyStats struct {
+	InFlight    int   `json:"inFlight"`
+	Queued      int64 `json:"queued"`
+	Waiting     int64 `json:"waiting"`
+	MaxInFlight int   `json:"maxInFlight"`
+	MaxQueue    int64 `json:"maxQueue"`
+	// totals since the start
+	Admitted int64 `json:"admitted"`
+	Rejected int64 `json:"rejected"`
+	TimedOut int64 `json:"timedOut"`
+}
+
+// NewConcurrencyLimiter returns an error for a limiter that would never
+// admit a call
+func NewConcurrencyLimiter(concurrencyConfig config.LlmConcurrencyConfig) (*ConcurrencyLimiter, error) {
+	if concurrencyConfig.MaxInFlight <= 0 {
+		return nil, fmt.Errorf("llmconcurrency.maxinflight must be positive, got %d", concurrencyConfig.MaxInFlight)
+	}
+	if concurrencyConfig.MaxQueue < 0 {
+		return nil, fmt.Errorf("llmconcurrency.maxqueue must not be negative, got %d", concurrencyConfig.MaxQueue)
+	}
+
+	return &ConcurrencyLimiter{
+		slots:           make(chan struct{}, concurrencyConfig.MaxInFlight),
+		maxQueue:        int64(concurrencyConfig.MaxQueue),
+		queueTimeout:    time.Duration(concurrencyConfig.QueueTimeoutInMs) * time.Millisecond,
+		retryAfterInSec: concurrencyConfig.RetryAfterInSec,
+	}, nil
+}
+
+type waitForSlotKey struct{}
+
+// WaitForSlot marks the context of background work, such as queued jobs,
+// whose calls wait for a slot as long as ctx allows instead of being rejected
+func WaitForSlot(ctx context.Context) context.Context {
+	return context.WithValue(ctx, waitForSlotKey{}, true)
+}
+
+// Call runs call while holding a slot
+func (l *ConcurrencyLimiter) Call(ctx context.Context, call func() error) error {
+	release, err := l.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return call()
+}
+
+// Acquire takes a slot, waiting for one when none is free. It returns
+// ErrSaturated right away when the queue is full, or once the call waited
+// for the queue timeout. The returned function frees the slot.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+		l.admitted.Add(1)
+		return l.release, nil
+	default:
+	}
+
+	if ctx.Value(waitForSlotKey{}) != nil {
+		l.waiting.Add(1)
+		defer l.waiting.Add(-1)
+
+		select {
+		case l.slots <- struct{}{}:
+			l.admitted.Add(1)
+			return l.release, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		l.rejected.Add(1)
+		return nil, ErrSaturated
+	}
+	defer l.queued.Add(-1)
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		l.admitted.Add(1)
+		return l.release, nil
+	case <-timer.C:
+		l.timedOut.Add(1)
+		return nil, ErrSaturated
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *ConcurrencyLimiter) release() {
+	<-l.slots
+}
+
+func (l *ConcurrencyLimiter) Stats() ConcurrencyStats {
+	return ConcurrencyStats{
+		InFlight:    len(l.slots),
+		Queued:      l.queued.Load(),
+		Waiting:     l.waiting.Load(),
+		MaxInFlight: cap(l.slots),
+		MaxQueue:    l.maxQueue,
+		Admitted:    l.admitted.Load(),
+		Rejected:    l.rejected.Load(),
+		TimedOut:    l.timedOut.Load(),
+	}
+}
+
+// Saturated reports whether every slot is taken and the queue is full, a
+// request's call made now would be rejected. Background calls waiting for a
+// slot don't count.
+func (l *ConcurrencyLimiter) Saturated() bool {
+	return len(l.slots) == cap(l.slots) && l.queued.Load() >= l.maxQueue
+}
+
+// RespondBusy answers that the server is too busy, with when to retry
+func (l *ConcurrencyLimiter) RespondBusy(c *gin.Context) {
+	c.Header("Retry-After", strconv.Itoa(l.retryAfterInSec))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server busy"})
+}
+
+// ConcurrencyMiddleware sheds requests with 503 while the limiter is
+// saturated, before their body is read, so uploads that would wait for a
+// provider call don't pile up in memory. It holds no slot, the handlers take
+// one for every provider call they make.
+func ConcurrencyMiddleware(limiter *ConcurrencyLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter.Saturated() {
+			limiter.rejected.Add(1)
+			limiter.RespondBusy(c)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
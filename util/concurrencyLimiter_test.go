@@ -0,0 +1,130 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/timemachine-app/timemachine-be/internal/config"
+)
+
+func TestNewConcurrencyLimiterRejectsNoSlots(t *testing.T) {
+	for _, maxInFlight := range []int{0, -1} {
+		if _, err := NewConcurrencyLimiter(config.LlmConcurrencyConfig{MaxInFlight: maxInFlight}); err == nil {
+			t.Errorf("NewConcurrencyLimiter with maxinflight %d succeeded", maxInFlight)
+		}
+	}
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(config.LlmConcurrencyConfig{
+		MaxInFlight: 1, MaxQueue: 1, QueueTimeoutInMs: 60000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if limiter.Saturated() {
+		t.Error("saturated with the queue empty")
+	}
+
+	// a request waits in the queue until it gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan error)
+	go func() {
+		_, err := limiter.Acquire(ctx)
+		queued <- err
+	}()
+	for limiter.Stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if !limiter.Saturated() {
+		t.Error("not saturated with every slot taken and the queue full")
+	}
+	if _, err := limiter.Acquire(context.Background()); !errors.Is(err, ErrSaturated) {
+		t.Errorf("Acquire with the queue full = %v, want ErrSaturated", err)
+	}
+	cancel()
+	if err := <-queued; !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire of a canceled request = %v, want context.Canceled", err)
+	}
+
+	release()
+	stats := limiter.Stats()
+	if stats.InFlight != 0 || stats.Queued != 0 || stats.Admitted != 1 || stats.Rejected != 1 {
+		t.Errorf("Stats = %+v, want 1 admitted, 1 rejected and none in flight or queued", stats)
+	}
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(config.LlmConcurrencyConfig{
+		MaxInFlight: 1, MaxQueue: 1, QueueTimeoutInMs: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	defer release()
+
+	if _, err := limiter.Acquire(context.Background()); !errors.Is(err, ErrSaturated) {
+		t.Errorf("Acquire with every slot taken = %v, want ErrSaturated", err)
+	}
+	if stats := limiter.Stats(); stats.TimedOut != 1 || stats.Queued != 0 {
+		t.Errorf("Stats = %+v, want 1 timed out and none queued", stats)
+	}
+}
+
+func TestConcurrencyLimiterBackgroundWaitsOutsideQueue(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(config.LlmConcurrencyConfig{
+		MaxInFlight: 1, MaxQueue: 1, QueueTimeoutInMs: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	// background work waits until the slot is freed
+	acquired := make(chan error)
+	go func() {
+		release, err := limiter.Acquire(WaitForSlot(context.Background()))
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	for limiter.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the waiting job neither fills the queue nor sheds requests
+	if limiter.Saturated() {
+		t.Error("saturated by a waiting background call")
+	}
+	if _, err := limiter.Acquire(context.Background()); !errors.Is(err, ErrSaturated) {
+		t.Errorf("Acquire with every slot taken = %v, want ErrSaturated", err)
+	}
+	if stats := limiter.Stats(); stats.Queued != 0 || stats.TimedOut != 1 || stats.Rejected != 0 {
+		t.Errorf("Stats = %+v, want the request timed out in the queue rather than rejected", stats)
+	}
+
+	release()
+	if err := <-acquired; err != nil {
+		t.Errorf("Acquire of background work: %v", err)
+	}
+	if stats := limiter.Stats(); stats.InFlight != 0 || stats.Waiting != 0 || stats.Admitted != 2 {
+		t.Errorf("Stats = %+v, want 2 admitted and none in flight or waiting", stats)
+	}
+}
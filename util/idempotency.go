@@ -0,0 +1,198 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timemachine-app/timemachine-be/idempotency"
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/superbase"
+	"github.com/timemachine-app/timemachine-be/vault"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+)
+
+// responseRecorder keeps a copy of the response body so it can be stored
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(data string) (int, error) {
+	r.body.WriteString(data)
+	return r.ResponseWriter.WriteString(data)
+}
+
+// IdempotencyMiddleware replays the stored response of a request retried with
+// the same Idempotency-Key. Keys are scoped to the user and route, reusing a
+// key with a different payload is rejected, and server errors aren't stored so
+// they can be retried. Stored responses are encrypted with the user's data
+// key, or the master key for requests without a user. Requests without the
+// header pass through.
+func IdempotencyMiddleware(
+	store idempotency.Store, vault *vault.Vault, idempotencyConfig config.IdempotencyConfig) gin.HandlerFunc {
+	ttl := time.Duration(idempotencyConfig.TtlInSec) * time.Second
+	lockTtl := time.Duration(idempotencyConfig.LockTtlInSec) * time.Second
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid idempotency key"})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, idempotencyConfig.MaxBodyInBytes))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request too large"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// keys of anonymous clients aren't tied to their IP, which changes
+		// with the network; the fingerprint keeps others' payloads out
+		userId := c.GetString(UserIdContextKey)
+		scopedKey := userId + ":" + c.Request.Method + ":" + c.FullPath() + ":" + key
+		fingerprint := requestFingerprint(c, body)
+
+		state, record, err := store.Begin(scopedKey, fingerprint, lockTtl)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error processing request"})
+			return
+		}
+
+		switch state {
+		case idempotency.StateMismatch:
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity,
+				gin.H{"error": "idempotency key was already used with a different request"})
+			return
+		case idempotency.StateInProgress:
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusConflict,
+				gin.H{"error": "a request with this idempotency key is in progress"})
+			return
+		case idempotency.StateCompleted:
+			body, err := openResponseBody(vault, userId, scopedKey, record.Body)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error processing request"})
+				return
+			}
+			c.Header(idempotencyReplayedHeader, "true")
+			c.Data(record.Status, record.ContentType, body)
+			c.Abort()
+			return
+		}
+
+		// a panicking handler mustn't leave the key claimed until the lock
+		// expires
+		completed := false
+		defer func() {
+			if !completed {
+				store.Release(scopedKey)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			return
+		}
+		sealedBody, err := sealResponseBody(vault, userId, scopedKey, recorder.body.Bytes())
+		if err != nil {
+			// released, the retry is processed again
+			return
+		}
+		completed = true
+		store.Complete(scopedKey, idempotency.Record{
+			Fingerprint: fingerprint,
+			Status:      recorder.Status(),
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        sealedBody,
+		}, ttl)
+	}
+}
+
+// sealResponseBody encrypts with the user's data key. Responses of requests
+// without a user, or of users without a key, such as one that was just
+// deleted, are encrypted with the master key instead of creating a key.
+func sealResponseBody(vault *vault.Vault, userId string, scopedKey string, body []byte) ([]byte, error) {
+	if userId != "" {
+		sealed, err := vault.EncryptExisting(userId, body)
+		if !errors.Is(err, superbase.ErrNotFound) {
+			return []byte(sealed), err
+		}
+	}
+	sealed, err := vault.SealSecret(idempotencyLabel(scopedKey), body)
+	return []byte(sealed), err
+}
+
+// openResponseBody decrypts with the user's data key, or the master key for
+// responses sealed while the user had no key
+func openResponseBody(vault *vault.Vault, userId string, scopedKey string, sealed []byte) ([]byte, error) {
+	if userId != "" {
+		body, err := vault.Decrypt(userId, string(sealed))
+		if err == nil {
+			return body, nil
+		}
+		if body, secretErr := vault.OpenSecret(idempotencyLabel(scopedKey), string(sealed)); secretErr == nil {
+			return body, nil
+		}
+		return nil, err
+	}
+	return vault.OpenSecret(idempotencyLabel(scopedKey), string(sealed))
+}
+
+// idempotencyLabel binds a response sealed with the master key to its key
+func idempotencyLabel(scopedKey string) string {
+	return "idempotency:" + scopedKey
+}
+
+// requestFingerprint hashes what identifies the payload of a request. The
+// multipart boundary is left out as clients pick a new one on every retry.
+func requestFingerprint(c *gin.Context, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.URL.RawQuery))
+	hash.Write([]byte{0})
+	if boundary := multipartBoundary(c); boundary != "" {
+		body = bytes.ReplaceAll(body, []byte(boundary), nil)
+	}
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func multipartBoundary(c *gin.Context) string {
+	if c.ContentType() != "multipart/form-data" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return params["boundary"]
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timemachine-app/timemachine-be/idempotency"
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/superbase"
+	"github.com/timemachine-app/timemachine-be/vault"
+)
+
+// The response of a request whose user has no data key, such as the account
+// deletion that just removed it, is stored without creating a key
+func TestIdempotencyMiddlewareUserWithoutDataKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var keysAdded atomic.Int64
+	supabase := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			keysAdded.Add(1)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		fmt.Fprint(w, `[]`)
+	}))
+	defer supabase.Close()
+	userVault, err := vault.NewVault("test master secret", superbase.NewSupabaseClient(config.SuperbaseConfig{
+		Url: supabase.URL, DataKeyTableName: "data_keys",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := idempotency.NewMemoryStore()
+	calls := 0
+	router := gin.New()
+	router.POST("/delete",
+		func(c *gin.Context) { c.Set(UserIdContextKey, "user-1") },
+		IdempotencyMiddleware(store, userVault, config.IdempotencyConfig{TtlInSec: 60, LockTtlInSec: 60, MaxBodyInBytes: 1024}),
+		func(c *gin.Context) {
+			calls++
+			c.JSON(http.StatusOK, gin.H{"success": "true"})
+		})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/delete", strings.NewReader(`{}`))
+		req.Header.Set(IdempotencyKeyHeader, "key")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusOK || recorder.Body.String() != `{"success":"true"}` {
+			t.Errorf("request %d: %d %s, want the stored response", i, recorder.Code, recorder.Body.String())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want once", calls)
+	}
+	if keysAdded.Load() != 0 {
+		t.Errorf("%d data keys created for storing the response", keysAdded.Load())
+	}
+}
@@ -0,0 +1,203 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// minJwksRefetchInterval keeps tokens with unknown key ids from making us
+// fetch the key set on every request
+const minJwksRefetchInterval = time.Minute
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKS caches the public keys published at a JSON Web Key Set url. Keys are
+// refetched once the cache is older than the refresh interval, or early when
+// a token names a key id we haven't seen, which is how providers rotate keys.
+// If a refetch fails the keys already known keep being used.
+type JWKS struct {
+	url                string
+	refreshInterval    time.Duration
+	minRefetchInterval time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+
+	// concurrent refreshes wait for the fetch in progress
+	fetches singleflight.Group
+}
+
+func NewJWKS(url string, refreshInterval time.Duration) *JWKS {
+	return &JWKS{
+		url:                url,
+		refreshInterval:    refreshInterval,
+		minRefetchInterval: minJwksRefetchInterval,
+		keys:               make(map[string]crypto.PublicKey),
+	}
+}
+
+// Key returns the public key with the given key id
+func (j *JWKS) Key(kid string) (crypto.PublicKey, error) {
+	j.mu.RLock()
+	key, ok := j.keys[kid]
+	stale := time.Since(j.fetchedAt) > j.refreshInterval
+	j.mu.RUnlock()
+	if ok && !stale {
+		return key, nil
+	}
+
+	if err := j.refresh(); err != nil && !ok {
+		return nil, err
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (j *JWKS) refresh() error {
+	_, err, _ := j.fetches.Do(j.url, func() (interface{}, error) {
+		return nil, j.fetch()
+	})
+	return err
+}
+
+// fetch replaces the keys with the ones currently published. The keys stay
+// readable while the key set is fetched, only the swap takes the lock.
+func (j *JWKS) fetch() error {
+	j.mu.Lock()
+	if time.Since(j.attemptedAt) < j.minRefetchInterval {
+		j.mu.Unlock()
+		return nil
+	}
+	j.attemptedAt = time.Now()
+	j.mu.Unlock()
+
+	keys, err := fetchJWKS(j.url)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	return nil
+}
+
+func fetchJWKS(url string) (map[string]crypto.PublicKey, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks, status code: %d", resp.StatusCode)
+	}
+
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		return nil, fmt.Errorf("failed to decode jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// skip key types we don't support rather than failing the whole set
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
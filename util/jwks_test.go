@@ -0,0 +1,145 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keySetServer publishes the RSA public keys it holds and counts fetches
+type keySetServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetches int
+}
+
+func newKeySetServer(t *testing.T) *keySetServer {
+	server := &keySetServer{keys: map[string]*rsa.PublicKey{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.fetches++
+
+		keys := []jsonWebKey{}
+		for kid, key := range server.keys {
+			keys = append(keys, jsonWebKey{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *keySetServer) publish(t *testing.T, kid string) *rsa.PublicKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = &privateKey.PublicKey
+	return &privateKey.PublicKey
+}
+
+func (s *keySetServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func TestJWKSKeyIsCached(t *testing.T) {
+	server := newKeySetServer(t)
+	published := server.publish(t, "a")
+	jwks := NewJWKS(server.URL, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		key, err := jwks.Key("a")
+		if err != nil {
+			t.Fatalf("Key: %v", err)
+		}
+		if !published.Equal(key) {
+			t.Fatal("Key returned a different key than published")
+		}
+	}
+	if fetches := server.fetchCount(); fetches != 1 {
+		t.Errorf("fetched %d times, want 1", fetches)
+	}
+}
+
+func TestJWKSUnknownKidRefetches(t *testing.T) {
+	server := newKeySetServer(t)
+	server.publish(t, "a")
+	jwks := NewJWKS(server.URL, time.Hour)
+	jwks.minRefetchInterval = 0
+
+	if _, err := jwks.Key("a"); err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+
+	// the provider rotates to a key we haven't seen
+	rotated := server.publish(t, "b")
+	key, err := jwks.Key("b")
+	if err != nil {
+		t.Fatalf("Key after rotation: %v", err)
+	}
+	if !rotated.Equal(key) {
+		t.Fatal("Key returned a different key than published")
+	}
+	if fetches := server.fetchCount(); fetches != 2 {
+		t.Errorf("fetched %d times, want 2", fetches)
+	}
+}
+
+func TestJWKSUnknownKidRefetchIsThrottled(t *testing.T) {
+	server := newKeySetServer(t)
+	server.publish(t, "a")
+	jwks := NewJWKS(server.URL, time.Hour)
+
+	if _, err := jwks.Key("a"); err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := jwks.Key("unknown"); !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("Key(unknown) = %v, want ErrUnknownKey", err)
+		}
+	}
+	if fetches := server.fetchCount(); fetches != 1 {
+		t.Errorf("fetched %d times, want 1", fetches)
+	}
+}
+
+func TestJWKSKeepsKeysWhenRefetchFails(t *testing.T) {
+	server := newKeySetServer(t)
+	server.publish(t, "a")
+	jwks := NewJWKS(server.URL, time.Nanosecond)
+	jwks.minRefetchInterval = 0
+
+	if _, err := jwks.Key("a"); err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	server.Close()
+
+	// stale and unreachable, the known key keeps being used
+	if _, err := jwks.Key("a"); err != nil {
+		t.Fatalf("Key with provider down: %v", err)
+	}
+	if _, err := jwks.Key("b"); err == nil {
+		t.Fatal("Key(b) with provider down succeeded")
+	}
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/ratelimit"
+)
+
+// RateLimiter limits the requests of each client identifier. Its
+// counts are kept in the store under its name, limiters sharing a store don't
+// share counts. Only allowed requests are counted, a rejected one costs
+// nothing.
+type RateLimiter struct {
+	store      ratelimit.Store
+	name       string
+	rateLimit  int
+	window     time.Duration
+	failClosed bool
+}
+
+func NewRateLimiter(name string, ratelimitConfig config.RateLimitConfig, store ratelimit.Store) *RateLimiter {
+	return &RateLimiter{
+		store:      store,
+		name:       name,
+		rateLimit:  ratelimitConfig.RateLimit,
+		window:     time.Duration(ratelimitConfig.WindowInSec) * time.Second,
+		failClosed: ratelimitConfig.FailClosed,
+	}
+}
+
+// Allow takes cost requests for the client and returns where the client
+// stands against its limit. Requests are let through when the store fails,
+// an unreachable Redis shouldn't take the service down, unless the limiter
+// fails closed.
+func (r *RateLimiter) Allow(clientIdentifier string, cost int) ratelimit.Result {
+	result, err := r.store.Allow(r.name+":"+clientIdentifier, r.rateLimit, r.window, cost)
+	if err != nil {
+		log.Printf("failed to check rate limit %s: %v", r.name, err)
+		// nothing is known about the limit, no headers but Retry-After are sent
+		return ratelimit.Result{Allowed: !r.failClosed}
+	}
+	return result
+}
+
+// SetRateLimitHeaders tells the client where it stands against the limit in
+// the fields of the IETF RateLimit header draft, and when to retry a rejected
+// request
+func SetRateLimitHeaders(c *gin.Context, result ratelimit.Result) {
+	if result.Limit > 0 {
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+	}
+	if !result.Allowed {
+		// retrying right away would be rejected again
+		c.Header("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// ClientIdentifier returns the authenticated user id, or the client IP for
+// anonymous requests
+func ClientIdentifier(c *gin.Context) string {
+	if userId := c.GetString(UserIdContextKey); userId != "" {
+		return userId
+	}
+	return c.ClientIP()
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/ratelimit"
+)
+
+// failingStore can't be reached, like Redis during an outage
+type failingStore struct{}
+
+func (failingStore) Allow(key string, limit int, window time.Duration, cost int) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func TestRateLimiterStoreFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		failClosed bool
+		want       bool
+	}{
+		{"fails open", false, true},
+		{"fails closed", true, false},
+	}
+	for _, test := range tests {
+		limiter := NewRateLimiter("test", config.RateLimitConfig{
+			RateLimit: 10, WindowInSec: 60, FailClosed: test.failClosed,
+		}, failingStore{})
+		if result := limiter.Allow("client", 1); result.Allowed != test.want {
+			t.Errorf("%s: Allowed = %v, want %v", test.name, result.Allowed, test.want)
+		}
+	}
+}
+
+func TestRateLimiterKeysByName(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	rateLimitConfig := config.RateLimitConfig{RateLimit: 1, WindowInSec: 60}
+	first := NewRateLimiter("first", rateLimitConfig, store)
+	second := NewRateLimiter("second", rateLimitConfig, store)
+
+	if !first.Allow("client", 1).Allowed {
+		t.Fatal("first request rejected")
+	}
+	if first.Allow("client", 1).Allowed {
+		t.Error("request over the limit allowed")
+	}
+	// limiters sharing a store don't share counts
+	if !second.Allow("client", 1).Allowed {
+		t.Error("other limiter's request rejected")
+	}
+}
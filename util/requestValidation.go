@@ -1,52 +1,81 @@
 package util
 
 import (
-	"fmt"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
-	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/keyset"
+	"github.com/timemachine-app/timemachine-be/revocation"
 	"github.com/timemachine-app/timemachine-be/superbase"
 )
 
-// TODO: Use Redis when you start horizontal scale
-var rateLimitStore = struct {
-	sync.Mutex
-	clients map[string][]int64
-}{
-	clients: make(map[string][]int64),
-}
+// UserIdContextKey holds the authenticated user id in the gin context
+const UserIdContextKey = "userId"
 
-// Function to validate JWT token and return userId or nil
-func validateToken(tokenString, jwtSecret string) *string {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate the algorithm used to sign the token
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(jwtSecret), nil
-	})
+// UserRoleContextKey holds the role of the authenticated user, empty for
+// regular users
+const UserRoleContextKey = "userRole"
+
+// SessionIdContextKey holds the session the access token was issued for
+const SessionIdContextKey = "sessionId"
+
+// AdminRole may act on accounts other than its own
+const AdminRole = "admin"
+
+// GuestRole marks anonymous users, who are rate limited as the guest plan
+const GuestRole = "guest"
+
+// guestAddressKeyPrefix keys the quota the guests of a client IP share
+const guestAddressKeyPrefix = "guestip:"
+
+// PlanContextKey holds the plan the authenticated user is rate limited by,
+// empty for the free plan
+const PlanContextKey = "plan"
+
+type tokenSubject struct {
+	UserId    string
+	Role      string
+	Plan      string
+	SessionId string
+	IssuedAt  time.Time
+}
 
+// Function to validate JWT token against the keyset and return its subject or nil
+func validateToken(tokenString string, keyset *keyset.Keyset) *tokenSubject {
+	claims, err := keyset.Verify(tokenString)
 	if err != nil {
 		return nil
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if userId, ok := claims["sub"].(string); ok {
-			return &userId
+	if userId, ok := claims["sub"].(string); ok {
+		role, _ := claims["role"].(string)
+		plan, _ := claims["plan"].(string)
+		sessionId, _ := claims["sid"].(string)
+		issuedAt, _ := claims["iat"].(float64)
+		return &tokenSubject{
+			UserId:    userId,
+			Role:      role,
+			Plan:      plan,
+			SessionId: sessionId,
+			IssuedAt:  time.Unix(int64(issuedAt), 0),
 		}
 	}
 
 	return nil
 }
 
-// Rate limiting + token validation middleware
+// Rate limiting + token validation middleware. Each route is checked against
+// the access level of its policy and counted against the policy's rate limit.
+// The limit is checked before access is, so that responses refusing access
+// carry the rate limit headers too and rejected credentials count against the
+// client's limit.
 func ValidationMiddleware(
-	ratelimitConfig config.RateLimitConfig, jwtSecret string, superbaseClient *superbase.SupabaseClient) gin.HandlerFunc {
+	routePolicies *RoutePolicies, keyset *keyset.Keyset,
+	superbaseClient *superbase.SupabaseClient, revocationStore revocation.Store,
+	sessionSet *revocation.SessionSet) gin.HandlerFunc {
+
 	return func(c *gin.Context) {
 		// Skip rate limiting for /health endpoint
 		if strings.HasPrefix(c.Request.URL.Path, "/health") {
@@ -56,52 +85,81 @@ func ValidationMiddleware(
 
 		clientIdentifier := c.ClientIP() // Default to IP address
 		authHeader := c.GetHeader("Authorization")
+		unauthorized := false
 
 		if strings.HasPrefix(authHeader, "Bearer ") {
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			userId := validateToken(tokenString, jwtSecret)
-			if userId != nil {
-				clientIdentifier = *userId
+			subject := validateToken(tokenString, keyset)
+			if subject != nil {
+				// tokens of deleted or disabled accounts stay valid until they expire
+				revoked, err := revocationStore.IsRevoked(subject.UserId, subject.IssuedAt)
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error processing request"})
+					return
+				}
+				// tokens issued before sessions were recorded have none
+				if revoked || (subject.SessionId != "" && sessionSet.IsRevoked(subject.SessionId)) {
+					subject = nil
+				}
+			}
+			if subject != nil {
+				clientIdentifier = subject.UserId
+				c.Set(UserIdContextKey, subject.UserId)
+				c.Set(UserRoleContextKey, subject.Role)
+				c.Set(SessionIdContextKey, subject.SessionId)
+				if subject.Role == GuestRole {
+					c.Set(PlanContextKey, GuestRole)
+				} else {
+					c.Set(PlanContextKey, subject.Plan)
+				}
 				superbaseClient.AddUsageEvent(superbase.UsageEvent{
-					UserId:    *userId,
+					UserId:    subject.UserId,
 					EventType: c.Request.URL.Path,
 				})
 			} else {
-				// Invalid token, return unauthorized error
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-				return
+				// Invalid or revoked token, counted against the IP and
+				// answered unauthorized
+				unauthorized = true
 			}
 		}
 
-		currentTime := time.Now().Unix()
-
-		rateLimitStore.Lock()
-		defer rateLimitStore.Unlock()
-
-		// Initialize the request times slice if not present
-		if _, exists := rateLimitStore.clients[clientIdentifier]; !exists {
-			rateLimitStore.clients[clientIdentifier] = []int64{}
-		}
-
-		// Append the current request time
-		rateLimitStore.clients[clientIdentifier] = append(rateLimitStore.clients[clientIdentifier], currentTime)
-
-		// Remove timestamps older than the time window
-		validTime := currentTime - ratelimitConfig.WindowInSec
-		validRequests := []int64{}
-		for _, t := range rateLimitStore.clients[clientIdentifier] {
-			if t > validTime {
-				validRequests = append(validRequests, t)
+		policy := routePolicies.policy(c.Request.Method, c.FullPath())
+		rateLimiter := policy.planRateLimiter(c.GetString(PlanContextKey))
+		result := rateLimiter.Allow(clientIdentifier, 1)
+		if result.Allowed && c.GetString(PlanContextKey) == GuestRole {
+			// anyone can make up new guests, the guests of an address share
+			// the quota of one
+			if ipResult := rateLimiter.Allow(guestAddressKeyPrefix+c.ClientIP(), 1); !ipResult.Allowed || ipResult.Remaining < result.Remaining {
+				result = ipResult
 			}
 		}
-		rateLimitStore.clients[clientIdentifier] = validRequests
-
-		// Check if the request count exceeds the limit
-		if len(validRequests) > ratelimitConfig.RateLimit {
+		SetRateLimitHeaders(c, result)
+		if !result.Allowed {
 			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
 			return
 		}
 
+		if unauthorized {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		switch policy.access {
+		case AccessAuthenticated:
+			if c.GetString(UserIdContextKey) == "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+				return
+			}
+		case AccessAdmin:
+			if c.GetString(UserIdContextKey) == "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+				return
+			}
+			if c.GetString(UserRoleContextKey) != AdminRole {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+				return
+			}
+		}
+
 		c.Next()
 	}
 }
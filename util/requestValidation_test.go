@@ -0,0 +1,63 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/ratelimit"
+	"github.com/timemachine-app/timemachine-be/revocation"
+)
+
+func TestValidationMiddlewareRateLimitHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	routePolicies, err := NewRoutePolicies(config.RoutePoliciesConfig{
+		Default: "authenticated",
+		Policies: map[string]config.PolicyConfig{
+			"authenticated": {Access: AccessAuthenticated, RateLimit: config.RateLimitConfig{RateLimit: 2, WindowInSec: 60}},
+			"public":        {Access: AccessPublic, RateLimit: config.RateLimitConfig{RateLimit: 2, WindowInSec: 60}},
+		},
+		Routes: []config.RoutePolicyConfig{{Method: http.MethodGet, Path: "/public", Policy: "public"}},
+	}, ratelimit.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(ValidationMiddleware(routePolicies, nil, nil, revocation.NewMemoryStore(), nil))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/health", ok)
+	router.GET("/public", ok)
+	router.GET("/private", ok)
+
+	tests := []struct {
+		path          string
+		wantCode      int
+		wantRemaining string
+		wantRetry     bool
+	}{
+		{"/public", http.StatusOK, "1", false},
+		{"/private", http.StatusUnauthorized, "1", false},
+		{"/private", http.StatusUnauthorized, "0", false},
+		// refused access counts against the limit
+		{"/private", http.StatusTooManyRequests, "0", true},
+		{"/health", http.StatusOK, "", false},
+	}
+	for i, test := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+		if recorder.Code != test.wantCode {
+			t.Errorf("request %d to %s: status = %d, want %d", i, test.path, recorder.Code, test.wantCode)
+		}
+		if remaining := recorder.Header().Get("RateLimit-Remaining"); remaining != test.wantRemaining {
+			t.Errorf("request %d to %s: RateLimit-Remaining = %q, want %q", i, test.path, remaining, test.wantRemaining)
+		}
+		if retry := recorder.Header().Get("Retry-After") != ""; retry != test.wantRetry {
+			t.Errorf("request %d to %s: Retry-After sent = %v, want %v", i, test.path, retry, test.wantRetry)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/ratelimit"
+)
+
+const (
+	AccessPublic        = "public"
+	AccessAuthenticated = "authenticated"
+	AccessAdmin         = "admin"
+)
+
+type routePolicy struct {
+	name        string
+	access      string
+	rateLimiter *RateLimiter
+	// limiters of plans with their own limit
+	planRateLimiters map[string]*RateLimiter
+}
+
+// RoutePolicies resolves the access level and rate limit of a route from the
+// policy table in config. Routes that aren't listed get the default policy.
+type RoutePolicies struct {
+	defaultPolicy *routePolicy
+	routes        map[string]*routePolicy
+}
+
+func NewRoutePolicies(routePoliciesConfig config.RoutePoliciesConfig, store ratelimit.Store) (*RoutePolicies, error) {
+	policies := make(map[string]*routePolicy)
+	for name, policyConfig := range routePoliciesConfig.Policies {
+		switch policyConfig.Access {
+		case AccessPublic, AccessAuthenticated, AccessAdmin:
+		default:
+			return nil, fmt.Errorf("policy %s has unknown access %q", name, policyConfig.Access)
+		}
+		policy := &routePolicy{
+			name:             name,
+			access:           policyConfig.Access,
+			rateLimiter:      NewRateLimiter("policy:"+name, policyConfig.RateLimit, store),
+			planRateLimiters: make(map[string]*RateLimiter),
+		}
+		for plan, planConfig := range policyConfig.PlanRateLimits {
+			policy.planRateLimiters[plan] = NewRateLimiter("policy:"+name+":"+plan, planConfig, store)
+		}
+		policies[name] = policy
+	}
+
+	defaultPolicy, ok := policies[routePoliciesConfig.Default]
+	if !ok {
+		return nil, fmt.Errorf("unknown default policy %q", routePoliciesConfig.Default)
+	}
+
+	routes := make(map[string]*routePolicy)
+	for _, route := range routePoliciesConfig.Routes {
+		policy, ok := policies[route.Policy]
+		if !ok {
+			return nil, fmt.Errorf("route %s %s has unknown policy %q", route.Method, route.Path, route.Policy)
+		}
+		routes[routeKey(route.Method, route.Path)] = routeLimits(policy, route, store)
+	}
+
+	return &RoutePolicies{
+		defaultPolicy: defaultPolicy,
+		routes:        routes,
+	}, nil
+}
+
+// policy returns the policy of the route pattern, e.g. "/events/:id"
+func (p *RoutePolicies) policy(method string, path string) *routePolicy {
+	if policy, ok := p.routes[routeKey(method, path)]; ok {
+		return policy
+	}
+	return p.defaultPolicy
+}
+
+// planRateLimiter returns the limiter of the plan, or the policy's own when the
+// plan has no limit of its own
+func (p *routePolicy) planRateLimiter(plan string) *RateLimiter {
+	if rateLimiter, ok := p.planRateLimiters[plan]; ok {
+		return rateLimiter
+	}
+	return p.rateLimiter
+}
+
+// routeLimits returns the policy with the limits set on the route replacing
+// the policy's. They are counted for the route alone, the limits it doesn't
+// set stay shared with the other routes of the policy.
+func routeLimits(policy *routePolicy, route config.RoutePolicyConfig, store ratelimit.Store) *routePolicy {
+	if route.RateLimit.RateLimit == 0 && len(route.PlanRateLimits) == 0 {
+		return policy
+	}
+
+	name := "route:" + routeKey(route.Method, route.Path)
+	routePolicy := &routePolicy{
+		name:             policy.name,
+		access:           policy.access,
+		rateLimiter:      policy.rateLimiter,
+		planRateLimiters: make(map[string]*RateLimiter),
+	}
+	if route.RateLimit.RateLimit > 0 {
+		routePolicy.rateLimiter = NewRateLimiter(name, route.RateLimit, store)
+	}
+	for plan, rateLimiter := range policy.planRateLimiters {
+		routePolicy.planRateLimiters[plan] = rateLimiter
+	}
+	for plan, planConfig := range route.PlanRateLimits {
+		routePolicy.planRateLimiters[plan] = NewRateLimiter(name+":"+plan, planConfig, store)
+	}
+	return routePolicy
+}
+
+func routeKey(method string, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
@@ -0,0 +1,68 @@
+package vault
+
+import (
+	"container/list"
+	"sync"
+)
+
+// dataKeyCache keeps the most recently used data keys. Evicted keys are read
+// from Supabase again on their next use.
+type dataKeyCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type cachedDataKey struct {
+	userId  string
+	dataKey []byte
+}
+
+func newDataKeyCache(capacity int) *dataKeyCache {
+	return &dataKeyCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *dataKeyCache) get(userId string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[userId]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedDataKey).dataKey, true
+}
+
+func (c *dataKeyCache) put(userId string, dataKey []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[userId]; ok {
+		element.Value.(*cachedDataKey).dataKey = dataKey
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[userId] = c.order.PushFront(&cachedDataKey{userId: userId, dataKey: dataKey})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedDataKey).userId)
+	}
+}
+
+func (c *dataKeyCache) remove(userId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[userId]; ok {
+		c.order.Remove(element)
+		delete(c.entries, userId)
+	}
+}
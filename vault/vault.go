@@ -0,0 +1,205 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/timemachine-app/timemachine-be/superbase"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	dataKeySize = 32
+	// data keys kept in memory, the least recently used are dropped
+	maxCachedDataKeys = 10000
+)
+
+// Vault encrypts user content with a per-user data key. Data keys are stored
+// wrapped by the master key, so deleting a user's key makes every stored
+// ciphertext of that user unreadable.
+type Vault struct {
+	masterKey      []byte
+	supabaseClient *superbase.SupabaseClient
+
+	dataKeys *dataKeyCache
+	// loads of the same user's key share one Supabase round trip, loads of
+	// different users don't wait for each other
+	loads singleflight.Group
+}
+
+func NewVault(masterSecret string, supabaseClient *superbase.SupabaseClient) (*Vault, error) {
+	if masterSecret == "" {
+		return nil, errors.New("encryption key is not set")
+	}
+
+	masterKey := sha256.Sum256([]byte(masterSecret))
+	return &Vault{
+		masterKey:      masterKey[:],
+		supabaseClient: supabaseClient,
+		dataKeys:       newDataKeyCache(maxCachedDataKeys),
+	}, nil
+}
+
+// Encrypt seals plaintext with the user's data key, creating the key on first use
+func (v *Vault) Encrypt(userId string, plaintext []byte) (string, error) {
+	dataKey, err := v.dataKey(userId, true)
+	if err != nil {
+		return "", err
+	}
+
+	return seal(dataKey, plaintext, []byte(userId))
+}
+
+// EncryptExisting seals plaintext with the user's data key without creating
+// one. It returns superbase.ErrNotFound for users without a key, such as
+// deleted ones.
+func (v *Vault) EncryptExisting(userId string, plaintext []byte) (string, error) {
+	dataKey, err := v.dataKey(userId, false)
+	if err != nil {
+		return "", err
+	}
+
+	return seal(dataKey, plaintext, []byte(userId))
+}
+
+func (v *Vault) Decrypt(userId string, ciphertext string) ([]byte, error) {
+	dataKey, err := v.dataKey(userId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(dataKey, ciphertext, []byte(userId))
+}
+
+// Forget deletes the user's data key, leaving their stored content unreadable
+func (v *Vault) Forget(userId string) error {
+	v.dataKeys.remove(userId)
+
+	return v.supabaseClient.DeleteDataKey(userId)
+}
+
+// SealSecret encrypts a service secret that belongs to no user, such as a
+// signing key, with the master key. The label binds the ciphertext to what it
+// is stored as.
+func (v *Vault) SealSecret(label string, plaintext []byte) (string, error) {
+	return seal(v.masterKey, plaintext, []byte(label))
+}
+
+func (v *Vault) OpenSecret(label string, ciphertext string) ([]byte, error) {
+	return open(v.masterKey, ciphertext, []byte(label))
+}
+
+func (v *Vault) dataKey(userId string, create bool) ([]byte, error) {
+	if dataKey, ok := v.dataKeys.get(userId); ok {
+		return dataKey, nil
+	}
+
+	// creating and only reading don't share a load, a read mustn't report
+	// not found for a key a concurrent create is about to add
+	loadKey := userId
+	if create {
+		loadKey = "create:" + userId
+	}
+	dataKey, err, _ := v.loads.Do(loadKey, func() (interface{}, error) {
+		return v.loadDataKey(userId, create)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataKey.([]byte), nil
+}
+
+func (v *Vault) loadDataKey(userId string, create bool) ([]byte, error) {
+	stored, err := v.supabaseClient.GetDataKey(userId)
+	if err == nil {
+		return v.unwrapDataKey(userId, stored)
+	}
+	if !errors.Is(err, superbase.ErrNotFound) || !create {
+		return nil, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrappedKey, err := seal(v.masterKey, dataKey, []byte(userId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	err = v.supabaseClient.AddDataKey(superbase.DataKey{
+		UserId:     userId,
+		WrappedKey: wrappedKey,
+	})
+	if errors.Is(err, superbase.ErrConflict) {
+		// another instance created the user's first key at the same time,
+		// use the one that was stored
+		stored, err := v.supabaseClient.GetDataKey(userId)
+		if err != nil {
+			return nil, err
+		}
+		return v.unwrapDataKey(userId, stored)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v.dataKeys.put(userId, dataKey)
+	return dataKey, nil
+}
+
+func (v *Vault) unwrapDataKey(userId string, stored superbase.DataKey) ([]byte, error) {
+	dataKey, err := open(v.masterKey, stored.WrappedKey, []byte(userId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	v.dataKeys.put(userId, dataKey)
+	return dataKey, nil
+}
+
+// seal encrypts with AES-GCM and returns base64(nonce || ciphertext)
+func seal(key []byte, plaintext []byte, additionalData []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, additionalData)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func open(key []byte, ciphertext string, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
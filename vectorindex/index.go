@@ -0,0 +1,147 @@
+package vectorindex
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Hit struct {
+	EventId string
+	Score   float64
+}
+
+// Index is an in-memory, per-user cosine similarity index of event embeddings.
+// Each user's vectors carry the version of the stored events they were loaded
+// from, and users not searched for a while are dropped.
+type Index struct {
+	mu    sync.RWMutex
+	users map[string]*userVectors
+	// how long a user's vectors are kept without a search
+	idleTtl time.Duration
+}
+
+type userVectors struct {
+	version  string
+	vectors  map[string]IndexedEvent
+	lastUsed time.Time
+}
+
+// IndexedEvent is the embedding of a stored event and the time it happened
+type IndexedEvent struct {
+	EventTime string
+	Vector    []float32
+}
+
+func NewIndex(idleTtl time.Duration) *Index {
+	return &Index{
+		users:   make(map[string]*userVectors),
+		idleTtl: idleTtl,
+	}
+}
+
+// Current reports whether the user's vectors were loaded from the given
+// version of their stored events
+func (i *Index) Current(userId string, version string) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	user, ok := i.users[userId]
+	return ok && user.version == version
+}
+
+// Load replaces every vector of the user and drops the vectors of idle users
+func (i *Index) Load(userId string, version string, vectors map[string]IndexedEvent) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	for idleUserId, user := range i.users {
+		if now.Sub(user.lastUsed) > i.idleTtl {
+			delete(i.users, idleUserId)
+		}
+	}
+	i.users[userId] = &userVectors{
+		version:  version,
+		vectors:  vectors,
+		lastUsed: now,
+	}
+}
+
+// Upsert adds or replaces a vector of a user whose index is already loaded.
+// Unloaded users pick the vector up with their next Load.
+func (i *Index) Upsert(userId string, eventId string, event IndexedEvent) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if user, ok := i.users[userId]; ok {
+		user.vectors[eventId] = event
+	}
+}
+
+func (i *Index) Remove(userId string, eventId string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if user, ok := i.users[userId]; ok {
+		delete(user.vectors, eventId)
+	}
+}
+
+// Drop forgets every vector of the user
+func (i *Index) Drop(userId string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.users, userId)
+}
+
+// Search returns the k events most similar to the query, best first. A non-nil
+// keep narrows the candidates down by event time before the top k are cut.
+func (i *Index) Search(userId string, query []float32, k int, keep func(eventTime string) bool) []Hit {
+	// a write lock, the search marks the user as used
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	user, ok := i.users[userId]
+	if !ok {
+		return []Hit{}
+	}
+	user.lastUsed = time.Now()
+
+	hits := []Hit{}
+	for eventId, event := range user.vectors {
+		if keep != nil && !keep(event.EventTime) {
+			continue
+		}
+		hits = append(hits, Hit{
+			EventId: eventId,
+			Score:   cosineSimilarity(query, event.Vector),
+		})
+	}
+
+	sort.Slice(hits, func(a, b int) bool {
+		if hits[a].Score == hits[b].Score {
+			return hits[a].EventId < hits[b].EventId
+		}
+		return hits[a].Score > hits[b].Score
+	})
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits
+}
+
+func cosineSimilarity(a []float32, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for idx := range a {
+		dot += float64(a[idx]) * float64(b[idx])
+		normA += float64(a[idx]) * float64(a[idx])
+		normB += float64(b[idx]) * float64(b[idx])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
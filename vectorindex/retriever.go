@@ -0,0 +1,253 @@
+package vectorindex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/timemachine-app/timemachine-be/internal/config"
+	"github.com/timemachine-app/timemachine-be/openai"
+	"github.com/timemachine-app/timemachine-be/superbase"
+	"github.com/timemachine-app/timemachine-be/util"
+	"github.com/timemachine-app/timemachine-be/vault"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	loadPageSize = 200
+	// users not searched for this long are dropped from the index
+	indexIdleTtl = 30 * time.Minute
+	// version of a load that left events out, no stored version matches it
+	incompleteVersion = "incomplete"
+)
+
+var errNotEmbedded = errors.New("event not embedded yet")
+
+type RetrievedEvent struct {
+	EventId   string          `json:"eventId"`
+	EventTime string          `json:"eventTime"`
+	Event     json.RawMessage `json:"event"`
+	Score     float64         `json:"score"`
+}
+
+// Retriever embeds stored events and answers top-k similarity queries over
+// them. Embeddings are persisted encrypted next to the event and loaded into
+// the local index on a user's first search. Every search checks when the
+// user's events last changed, so events added on another instance are loaded
+// too. Every embedding call takes a slot of the LLM limiter.
+type Retriever struct {
+	openAIConfig   config.OpenAIConfig
+	supabaseClient *superbase.SupabaseClient
+	vault          *vault.Vault
+	llmLimiter     *util.ConcurrencyLimiter
+	index          *Index
+
+	// loads of one user share a single pass, users load independently
+	loads singleflight.Group
+}
+
+func NewRetriever(
+	openAIConfig config.OpenAIConfig, supabaseClient *superbase.SupabaseClient, vault *vault.Vault,
+	llmLimiter *util.ConcurrencyLimiter) *Retriever {
+	return &Retriever{
+		openAIConfig:   openAIConfig,
+		supabaseClient: supabaseClient,
+		vault:          vault,
+		llmLimiter:     llmLimiter,
+		index:          NewIndex(indexIdleTtl),
+	}
+}
+
+// EmbedEvent embeds the event content and returns the vector together with
+// its encrypted form for storage
+func (r *Retriever) EmbedEvent(ctx context.Context, userId string, content []byte) ([]float32, string, error) {
+	vector, err := r.embed(ctx, string(content))
+	if err != nil {
+		return nil, "", err
+	}
+
+	vectorBytes, err := json.Marshal(vector)
+	if err != nil {
+		return nil, "", err
+	}
+	encrypted, err := r.vault.Encrypt(userId, vectorBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return vector, encrypted, nil
+}
+
+// Indexed records the vector of a stored event. A nil vector means embedding
+// failed, so the user's index is reloaded and backfilled on the next search.
+func (r *Retriever) Indexed(userId string, eventId string, eventTime string, vector []float32) {
+	if vector == nil {
+		r.index.Drop(userId)
+		return
+	}
+	r.index.Upsert(userId, eventId, IndexedEvent{EventTime: eventTime, Vector: vector})
+}
+
+func (r *Retriever) Removed(userId string, eventId string) {
+	r.index.Remove(userId, eventId)
+}
+
+func (r *Retriever) Forget(userId string) {
+	r.index.Drop(userId)
+}
+
+// Retrieve returns the k stored events most similar to the query, best first.
+// A non-nil keep limits the search to the events whose time it accepts.
+func (r *Retriever) Retrieve(ctx context.Context, userId string, query string, k int, keep func(eventTime string) bool) ([]RetrievedEvent, error) {
+	if err := r.ensureLoaded(ctx, userId); err != nil {
+		return nil, err
+	}
+
+	queryVector, err := r.embed(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.fetch(userId, r.index.Search(userId, queryVector, k, keep))
+}
+
+// Fetch returns the given stored events, e.g. the ones retrieved for an
+// earlier question of a search conversation
+func (r *Retriever) Fetch(userId string, eventIds []string) ([]RetrievedEvent, error) {
+	hits := make([]Hit, len(eventIds))
+	for i, eventId := range eventIds {
+		hits[i] = Hit{EventId: eventId}
+	}
+	return r.fetch(userId, hits)
+}
+
+func (r *Retriever) embed(ctx context.Context, text string) ([]float32, error) {
+	var vector []float32
+	err := r.llmLimiter.Call(ctx, func() (err error) {
+		vector, err = openai.CallEmbeddingAPI(text, r.openAIConfig.Key, r.openAIConfig.EmbeddingModel)
+		return err
+	})
+	return vector, err
+}
+
+func (r *Retriever) fetch(userId string, hits []Hit) ([]RetrievedEvent, error) {
+	eventIds := make([]string, len(hits))
+	for i, hit := range hits {
+		eventIds[i] = hit.EventId
+	}
+
+	storedEvents, err := r.supabaseClient.GetEventsByIds(userId, eventIds)
+	if err != nil {
+		return nil, err
+	}
+	storedById := make(map[string]superbase.StoredEvent, len(storedEvents))
+	for _, storedEvent := range storedEvents {
+		storedById[storedEvent.EventId] = storedEvent
+	}
+
+	retrieved := []RetrievedEvent{}
+	for _, hit := range hits {
+		storedEvent, ok := storedById[hit.EventId]
+		if !ok {
+			// deleted by another instance since the index was loaded
+			continue
+		}
+		content, err := r.vault.Decrypt(userId, storedEvent.Content)
+		if err != nil {
+			return nil, err
+		}
+		retrieved = append(retrieved, RetrievedEvent{
+			EventId:   storedEvent.EventId,
+			EventTime: storedEvent.EventTime,
+			Event:     content,
+			Score:     hit.Score,
+		})
+	}
+
+	return retrieved, nil
+}
+
+func (r *Retriever) ensureLoaded(ctx context.Context, userId string) error {
+	version, err := r.supabaseClient.GetLatestEventUpdate(userId)
+	if err != nil {
+		return err
+	}
+	if r.index.Current(userId, version) {
+		return nil
+	}
+
+	_, err, _ = r.loads.Do(userId, func() (interface{}, error) {
+		return nil, r.load(ctx, userId, version)
+	})
+	return err
+}
+
+// load reads the user's vectors as of version. Backfilling an embedding
+// changes the event, so the next search loads the user once more. Once the
+// limiter turned a backfill away, the remaining events without embedding are
+// left out instead of each waiting for a slot.
+func (r *Retriever) load(ctx context.Context, userId string, version string) error {
+	vectors := make(map[string]IndexedEvent)
+	backfill := true
+	for offset := 0; ; offset += loadPageSize {
+		storedEvents, err := r.supabaseClient.GetEvents(userId, loadPageSize, offset)
+		if err != nil {
+			return err
+		}
+
+		for _, storedEvent := range storedEvents {
+			vector, err := r.storedVector(ctx, storedEvent, backfill)
+			if errors.Is(err, util.ErrSaturated) {
+				backfill = false
+			}
+			if err != nil {
+				// left out of the search, the next load tries again
+				log.Printf("failed to load embedding of event %s: %v", storedEvent.EventId, err)
+				version = incompleteVersion
+				continue
+			}
+			vectors[storedEvent.EventId] = IndexedEvent{EventTime: storedEvent.EventTime, Vector: vector}
+		}
+
+		if len(storedEvents) < loadPageSize {
+			break
+		}
+	}
+
+	r.index.Load(userId, version, vectors)
+	return nil
+}
+
+// storedVector decrypts the stored embedding, embedding and persisting it
+// first for events stored without one when backfill is set
+func (r *Retriever) storedVector(ctx context.Context, storedEvent superbase.StoredEvent, backfill bool) ([]float32, error) {
+	if storedEvent.Embedding != "" {
+		vectorBytes, err := r.vault.Decrypt(storedEvent.UserId, storedEvent.Embedding)
+		if err != nil {
+			return nil, err
+		}
+		var vector []float32
+		if err := json.Unmarshal(vectorBytes, &vector); err != nil {
+			return nil, err
+		}
+		return vector, nil
+	}
+
+	if !backfill {
+		return nil, errNotEmbedded
+	}
+	content, err := r.vault.Decrypt(storedEvent.UserId, storedEvent.Content)
+	if err != nil {
+		return nil, err
+	}
+	vector, encrypted, err := r.EmbedEvent(ctx, storedEvent.UserId, content)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.supabaseClient.UpdateEventEmbedding(storedEvent.UserId, storedEvent.EventId, encrypted); err != nil {
+		return nil, err
+	}
+	return vector, nil
+}